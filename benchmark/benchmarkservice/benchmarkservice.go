@@ -0,0 +1,178 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package benchmarkservice is a reference BenchmarkServiceServer
+// implementation that synthesizes response payloads of the requested size
+// and type, and honors per-call compression requests, so that a QPS
+// scenario run against it measures realistic serialization, compression and
+// allocation costs rather than those of a bare echo server.
+package benchmarkservice
+
+import (
+	"context"
+	"io"
+	"math/rand"
+
+	"github.com/qiaohao9/grpc"
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+	"github.com/qiaohao9/grpc/metadata"
+)
+
+// Server is a reference testpb.BenchmarkServiceServer implementation.
+type Server struct {
+	testpb.UnimplementedBenchmarkServiceServer
+}
+
+// NewServer returns a Server ready to be registered on a grpc.Server.
+func NewServer() *Server {
+	return &Server{}
+}
+
+// UnaryCall returns a response payload of the size and type requested in
+// req, compressed per req's response_compressed flag.
+func (s *Server) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	payload, err := newPayload(req.GetResponseType(), req.GetResponseSize())
+	if err != nil {
+		return nil, err
+	}
+	if err := setCompression(ctx, req); err != nil {
+		return nil, err
+	}
+	return &testpb.SimpleResponse{Payload: payload}, nil
+}
+
+// StreamingCall echoes one response per request received, in the requested
+// size, type and compression, until the client closes its send side.
+func (s *Server) StreamingCall(stream testpb.BenchmarkService_StreamingCallServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		payload, err := newPayload(req.GetResponseType(), req.GetResponseSize())
+		if err != nil {
+			return err
+		}
+		if err := setCompression(stream.Context(), req); err != nil {
+			return err
+		}
+		if err := stream.Send(&testpb.SimpleResponse{Payload: payload}); err != nil {
+			return err
+		}
+		releasePayload(payload.Body)
+	}
+}
+
+// StreamingFromClient drains every request the client sends and, once the
+// client calls CloseSend, replies once with a response payload sized per
+// the last request received.
+func (s *Server) StreamingFromClient(stream testpb.BenchmarkService_StreamingFromClientClient) error {
+	var last *testpb.SimpleRequest
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		last = req
+	}
+	payload, err := newPayload(last.GetResponseType(), last.GetResponseSize())
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&testpb.SimpleResponse{Payload: payload})
+}
+
+// StreamingFromServer sends response payloads to the client until the
+// stream's context is done, sized and typed per the single request
+// received.
+func (s *Server) StreamingFromServer(req *testpb.SimpleRequest, stream testpb.BenchmarkService_StreamingFromServerServer) error {
+	if err := setCompression(stream.Context(), req); err != nil {
+		return err
+	}
+	for {
+		payload, err := newPayload(req.GetResponseType(), req.GetResponseSize())
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&testpb.SimpleResponse{Payload: payload}); err != nil {
+			return err
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		default:
+		}
+	}
+}
+
+// StreamingBothWays echoes one response per request received, independent
+// of the pace at which either side sends.
+func (s *Server) StreamingBothWays(stream testpb.BenchmarkService_StreamingBothWaysServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		payload, err := newPayload(req.GetResponseType(), req.GetResponseSize())
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&testpb.SimpleResponse{Payload: payload}); err != nil {
+			return err
+		}
+	}
+}
+
+// grpcInternalEncodingRequest is the trailer key grpc-go's transport layer
+// recognizes as a request, from the handler, to compress the response with
+// the named compressor, since there is no public API for a server handler
+// to select its own response encoding.
+const grpcInternalEncodingRequest = "grpc-internal-encoding-request"
+
+// setCompression requests that the response to this RPC be sent with gzip
+// compression when req's response_compressed field is set, and leaves the
+// response uncompressed otherwise.
+func setCompression(ctx context.Context, req *testpb.SimpleRequest) error {
+	if !req.GetResponseCompressed().GetValue() {
+		return nil
+	}
+	return grpc.SetTrailer(ctx, metadata.Pairs(grpcInternalEncodingRequest, "gzip"))
+}
+
+// newPayload builds a payload of size bytes, deterministic (all zeros) for
+// COMPRESSABLE (the common case for measuring compression ratios) or
+// pseudo-random for RANDOM (so compression cannot shrink it).
+func newPayload(t testpb.PayloadType, size int32) (*testpb.Payload, error) {
+	if size < 0 {
+		return nil, errInvalidSize(size)
+	}
+	body := payloadPool(size)
+	if t == testpb.PayloadType_RANDOM {
+		rand.Read(body)
+	}
+	return &testpb.Payload{Type: t, Body: body}, nil
+}
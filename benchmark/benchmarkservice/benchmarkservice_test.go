@@ -0,0 +1,67 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package benchmarkservice
+
+import (
+	"context"
+	"testing"
+
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+func TestUnaryCallRespectsResponseSize(t *testing.T) {
+	s := NewServer()
+	resp, err := s.UnaryCall(context.Background(), &testpb.SimpleRequest{ResponseSize: 1234})
+	if err != nil {
+		t.Fatalf("UnaryCall() failed: %v", err)
+	}
+	if got := len(resp.GetPayload().GetBody()); got != 1234 {
+		t.Errorf("len(Payload.Body) = %d, want 1234", got)
+	}
+}
+
+func TestUnaryCallInvalidSize(t *testing.T) {
+	s := NewServer()
+	if _, err := s.UnaryCall(context.Background(), &testpb.SimpleRequest{ResponseSize: -1}); err == nil {
+		t.Fatal("UnaryCall() with a negative response_size succeeded, want error")
+	}
+}
+
+func TestNewPayloadCompressable(t *testing.T) {
+	p, err := newPayload(testpb.PayloadType_COMPRESSABLE, 16)
+	if err != nil {
+		t.Fatalf("newPayload() failed: %v", err)
+	}
+	for i, b := range p.GetBody() {
+		if b != 0 {
+			t.Fatalf("COMPRESSABLE payload byte %d = %d, want 0", i, b)
+		}
+	}
+}
+
+func TestPayloadPoolReuse(t *testing.T) {
+	buf := payloadPool(1 << 10)
+	buf[0] = 0xFF
+	releasePayload(buf)
+
+	reused := payloadPool(1 << 10)
+	if reused[0] != 0 {
+		t.Errorf("reused buffer not zeroed: reused[0] = %d, want 0", reused[0])
+	}
+}
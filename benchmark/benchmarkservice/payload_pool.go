@@ -0,0 +1,84 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package benchmarkservice
+
+import (
+	"fmt"
+	"sync"
+)
+
+// sizeClasses are the payload sizes, in bytes, pooled by payloadPool. A
+// size not in this list falls back to a plain allocation, since a pool
+// entry for every possible size would never see enough reuse to pay for
+// itself.
+var sizeClasses = []int{0, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+var payloadPools = newPayloadPools()
+
+type payloadPoolSet struct {
+	pools map[int]*sync.Pool
+}
+
+func newPayloadPools() *payloadPoolSet {
+	s := &payloadPoolSet{pools: make(map[int]*sync.Pool, len(sizeClasses))}
+	for _, size := range sizeClasses {
+		size := size
+		s.pools[size] = &sync.Pool{New: func() interface{} { return make([]byte, size) }}
+	}
+	return s
+}
+
+// get returns a zeroed []byte of exactly size bytes, reused from a
+// sync.Pool when size matches one of sizeClasses.
+func (s *payloadPoolSet) get(size int) []byte {
+	pool, ok := s.pools[size]
+	if !ok {
+		return make([]byte, size)
+	}
+	buf := pool.Get().([]byte)
+	for i := range buf {
+		buf[i] = 0
+	}
+	return buf
+}
+
+// put returns buf to its size class's pool, if any, for reuse.
+func (s *payloadPoolSet) put(buf []byte) {
+	if pool, ok := s.pools[len(buf)]; ok {
+		pool.Put(buf)
+	}
+}
+
+// payloadPool returns a []byte of exactly size bytes, reused via
+// payloadPools when size falls into one of sizeClasses.
+func payloadPool(size int32) []byte {
+	return payloadPools.get(int(size))
+}
+
+// releasePayload returns body, previously obtained from payloadPool, to its
+// pool once the caller is done with it (i.e. after it has been serialized
+// onto the wire by a completed Send), to eliminate per-RPC allocations on
+// the streaming ping-pong path.
+func releasePayload(body []byte) {
+	payloadPools.put(body)
+}
+
+func errInvalidSize(size int32) error {
+	return fmt.Errorf("benchmarkservice: invalid payload size %d", size)
+}
@@ -0,0 +1,142 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package driver launches a set of gRPC QPS workers, drives a Scenario
+// against them for a configured duration, and collects the resulting
+// ServerStats/ClientStats into a single ScenarioResult.
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/credentials/insecure"
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+// WorkerAddr is the dial target of a worker process already listening on
+// its WorkerService control port (started by Launch, or out-of-band).
+type WorkerAddr string
+
+// Launch starts a worker binary per addr, listening on the port encoded in
+// addr, and returns a cleanup function that terminates every process it
+// started. binaryPath is the path to the compiled benchmark/worker binary.
+func Launch(binaryPath string, addrs []WorkerAddr) (func(), error) {
+	var cmds []*exec.Cmd
+	for _, addr := range addrs {
+		_, port, err := splitHostPort(string(addr))
+		if err != nil {
+			return nil, err
+		}
+		cmd := exec.Command(binaryPath, fmt.Sprintf("--driver_port=%d", port))
+		if err := cmd.Start(); err != nil {
+			for _, c := range cmds {
+				c.Process.Kill()
+			}
+			return nil, fmt.Errorf("driver: failed to start worker for %s: %v", addr, err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	return func() {
+		for _, c := range cmds {
+			c.Process.Kill()
+			c.Wait()
+		}
+	}, nil
+}
+
+// RunScenario connects to every worker in addrs, configures each as either a
+// server or a client per scenario, runs for scenario's configured duration,
+// and returns the aggregated ScenarioResult.
+func RunScenario(ctx context.Context, scenario *testpb.Scenario, addrs []WorkerAddr) (*testpb.ScenarioResult, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("driver: RunScenario requires at least one worker")
+	}
+
+	numServers := int(scenario.GetNumServers())
+	if numServers <= 0 || numServers >= len(addrs) {
+		return nil, fmt.Errorf("driver: scenario requests %d servers but only %d workers are available", numServers, len(addrs))
+	}
+
+	var servers, clients []*workerSession
+	for i, addr := range addrs {
+		cc, err := grpc.DialContext(ctx, string(addr), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, fmt.Errorf("driver: failed to dial worker %s: %v", addr, err)
+		}
+		defer cc.Close()
+
+		if i < numServers {
+			sess, err := startServer(ctx, cc, scenario)
+			if err != nil {
+				return nil, err
+			}
+			servers = append(servers, sess)
+		} else {
+			sess, err := startClient(ctx, cc, scenario, serverTargets(servers))
+			if err != nil {
+				return nil, err
+			}
+			clients = append(clients, sess)
+		}
+	}
+
+	duration := time.Duration(scenario.GetWarmupSeconds()+scenario.GetBenchmarkSeconds()) * time.Second
+	select {
+	case <-time.After(duration):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	result := &testpb.ScenarioResult{ScenarioName: scenario.GetName()}
+	for _, sess := range clients {
+		stats, err := sess.mark()
+		if err != nil {
+			return nil, err
+		}
+		result.ClientStats = append(result.ClientStats, stats.GetClientStats())
+	}
+	for _, sess := range servers {
+		stats, err := sess.mark()
+		if err != nil {
+			return nil, err
+		}
+		result.ServerStats = append(result.ServerStats, stats.GetServerStats())
+	}
+	return result, nil
+}
+
+func serverTargets(servers []*workerSession) []string {
+	targets := make([]string, len(servers))
+	for i, s := range servers {
+		targets[i] = s.target
+	}
+	return targets
+}
+
+func splitHostPort(addr string) (string, string, error) {
+	for i := len(addr) - 1; i >= 0; i-- {
+		if addr[i] == ':' {
+			return addr[:i], addr[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("driver: %q is not a host:port address", addr)
+}
@@ -0,0 +1,43 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package driver
+
+import "testing"
+
+func TestSplitHostPort(t *testing.T) {
+	host, port, err := splitHostPort("localhost:10000")
+	if err != nil {
+		t.Fatalf("splitHostPort() failed: %v", err)
+	}
+	if host != "localhost" || port != "10000" {
+		t.Errorf("splitHostPort() = %q, %q, want %q, %q", host, port, "localhost", "10000")
+	}
+}
+
+func TestSplitHostPortInvalid(t *testing.T) {
+	if _, _, err := splitHostPort("no-port-here"); err == nil {
+		t.Fatal("splitHostPort() succeeded for an address with no port, want error")
+	}
+}
+
+func TestRunScenarioRequiresWorkers(t *testing.T) {
+	if _, err := RunScenario(nil, nil, nil); err == nil {
+		t.Fatal("RunScenario() with no workers succeeded, want error")
+	}
+}
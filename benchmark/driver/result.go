@@ -0,0 +1,38 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package driver
+
+import (
+	"io"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+// WriteJSON marshals result as indented JSON to w, in the same shape the
+// official C++/Java QPS drivers emit for cross-language result comparison.
+func WriteJSON(w io.Writer, result *testpb.ScenarioResult) error {
+	b, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,111 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/qiaohao9/grpc"
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+// workerSession is one worker's end of a running RunServer or RunClient
+// control stream, kept open for the duration of the scenario so a single
+// Mark can be sent at the end to collect final stats.
+type workerSession struct {
+	target string
+	server testpb.WorkerService_RunServerClient
+	client testpb.WorkerService_RunClientClient
+}
+
+func startServer(ctx context.Context, cc *grpc.ClientConn, scenario *testpb.Scenario) (*workerSession, error) {
+	worker := testpb.NewWorkerServiceClient(cc)
+	stream, err := worker.RunServer(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("driver: RunServer() failed: %v", err)
+	}
+	if err := stream.Send(&testpb.ServerArgs{Argtype: &testpb.ServerArgs_Setup{Setup: scenario.GetServerConfig()}}); err != nil {
+		return nil, fmt.Errorf("driver: failed to send ServerConfig: %v", err)
+	}
+	status, err := stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("driver: failed to receive initial ServerStatus: %v", err)
+	}
+	target := fmt.Sprintf("%s:%d", hostOf(cc.Target()), status.GetPort())
+	return &workerSession{target: target, server: stream}, nil
+}
+
+func startClient(ctx context.Context, cc *grpc.ClientConn, scenario *testpb.Scenario, serverTargets []string) (*workerSession, error) {
+	worker := testpb.NewWorkerServiceClient(cc)
+	stream, err := worker.RunClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("driver: RunClient() failed: %v", err)
+	}
+	cfg := scenario.GetClientConfig()
+	cfg.ServerTargets = serverTargets
+	if err := stream.Send(&testpb.ClientArgs{Argtype: &testpb.ClientArgs_Setup{Setup: cfg}}); err != nil {
+		return nil, fmt.Errorf("driver: failed to send ClientConfig: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		return nil, fmt.Errorf("driver: failed to receive initial ClientStatus: %v", err)
+	}
+	return &workerSession{client: stream}, nil
+}
+
+// mark sends a Mark and returns the stats snapshot it triggers. Exactly one
+// of the returned status's ServerStatus/ClientStatus fields is populated,
+// matching whether this session is running a server or a client.
+type markResult struct {
+	server *testpb.ServerStatus
+	client *testpb.ClientStatus
+}
+
+func (s *workerSession) mark() (markResult, error) {
+	if s.server != nil {
+		if err := s.server.Send(&testpb.ServerArgs{Argtype: &testpb.ServerArgs_Mark{Mark: &testpb.Mark{}}}); err != nil {
+			return markResult{}, err
+		}
+		status, err := s.server.Recv()
+		if err != nil {
+			return markResult{}, err
+		}
+		return markResult{server: status}, nil
+	}
+	if err := s.client.Send(&testpb.ClientArgs{Argtype: &testpb.ClientArgs_Mark{Mark: &testpb.Mark{}}}); err != nil {
+		return markResult{}, err
+	}
+	status, err := s.client.Recv()
+	if err != nil {
+		return markResult{}, err
+	}
+	return markResult{client: status}, nil
+}
+
+func (m markResult) GetServerStats() *testpb.ServerStats { return m.server.GetStats() }
+func (m markResult) GetClientStats() *testpb.ClientStats { return m.client.GetStats() }
+
+func hostOf(target string) string {
+	for i := len(target) - 1; i >= 0; i-- {
+		if target[i] == ':' {
+			return target[:i]
+		}
+	}
+	return target
+}
@@ -0,0 +1,128 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package load provides pluggable shapes of client-side load generation,
+// each answering the single question a benchmark runner's driving loop
+// needs: how long to wait before issuing the next RPC.
+package load
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Shape produces the inter-arrival delay to wait before starting the next
+// RPC. Implementations must be safe for concurrent use by multiple driving
+// goroutines, since a benchmark runner typically shares one Shape across
+// however many goroutines its concurrency setting requests.
+type Shape interface {
+	// Next returns how long to wait before starting the next RPC.
+	Next() time.Duration
+}
+
+// ClosedLoop drives RPCs back-to-back: the next RPC on a given goroutine
+// starts as soon as the previous one completes, so throughput is limited
+// only by how fast the backend and network can respond.
+type ClosedLoop struct{}
+
+// Next implements Shape.
+func (ClosedLoop) Next() time.Duration { return 0 }
+
+// FixedRate is an open-loop shape that paces RPCs evenly at QPS per second,
+// independent of how quickly prior RPCs completed.
+type FixedRate struct {
+	QPS float64
+}
+
+// Next implements Shape.
+func (f FixedRate) Next() time.Duration {
+	if f.QPS <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / f.QPS)
+}
+
+// Poisson is an open-loop shape producing exponentially-distributed
+// inter-arrival times with mean 1/Lambda, approximating a Poisson arrival
+// process at the target aggregate rate Lambda (in RPCs per second).
+type Poisson struct {
+	rnd    *rand.Rand
+	lambda float64
+}
+
+// NewPoisson returns a Poisson shape targeting lambda RPCs per second,
+// seeded from seed so that multiple Poisson shapes driven in parallel don't
+// produce identical sequences.
+func NewPoisson(lambda float64, seed int64) *Poisson {
+	return &Poisson{lambda: lambda, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// Next implements Shape.
+func (p *Poisson) Next() time.Duration {
+	if p.lambda <= 0 {
+		return 0
+	}
+	u := p.rnd.Float64()
+	for u == 0 {
+		u = p.rnd.Float64()
+	}
+	// -ln(U) / lambda is exponentially distributed with rate lambda.
+	seconds := -math.Log(u) / p.lambda
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Step switches between a sequence of Shapes, holding each for its
+// configured duration before moving to the next, then holding the last
+// Shape indefinitely. This can express both a step function (abrupt
+// transitions between flat Shapes) and a ramp (many short-lived FixedRate
+// steps at increasing QPS).
+type Step struct {
+	steps []stepEntry
+	start time.Time
+	now   func() time.Time
+}
+
+type stepEntry struct {
+	shape    Shape
+	duration time.Duration
+}
+
+// NewStep returns a Step shape that holds each of shapes for the
+// corresponding entry in durations before advancing to the next; the final
+// shape is held for the remainder of the run. len(shapes) must equal
+// len(durations).
+func NewStep(shapes []Shape, durations []time.Duration) *Step {
+	steps := make([]stepEntry, len(shapes))
+	for i, s := range shapes {
+		steps[i] = stepEntry{shape: s, duration: durations[i]}
+	}
+	return &Step{steps: steps, start: time.Now(), now: time.Now}
+}
+
+// Next implements Shape.
+func (s *Step) Next() time.Duration {
+	elapsed := s.now().Sub(s.start)
+	for _, step := range s.steps[:len(s.steps)-1] {
+		if elapsed < step.duration {
+			return step.shape.Next()
+		}
+		elapsed -= step.duration
+	}
+	return s.steps[len(s.steps)-1].shape.Next()
+}
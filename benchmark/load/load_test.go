@@ -0,0 +1,64 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package load
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClosedLoopNoDelay(t *testing.T) {
+	var s ClosedLoop
+	if d := s.Next(); d != 0 {
+		t.Errorf("ClosedLoop.Next() = %v, want 0", d)
+	}
+}
+
+func TestFixedRate(t *testing.T) {
+	s := FixedRate{QPS: 100}
+	if got, want := s.Next(), 10*time.Millisecond; got != want {
+		t.Errorf("FixedRate{QPS: 100}.Next() = %v, want %v", got, want)
+	}
+}
+
+func TestPoissonNeverNegative(t *testing.T) {
+	s := NewPoisson(1000, 7)
+	for i := 0; i < 100; i++ {
+		if d := s.Next(); d < 0 {
+			t.Fatalf("Poisson.Next() = %v, want >= 0", d)
+		}
+	}
+}
+
+func TestStepAdvancesThroughShapes(t *testing.T) {
+	now := time.Now()
+	s := NewStep(
+		[]Shape{FixedRate{QPS: 100}, FixedRate{QPS: 1000}},
+		[]time.Duration{time.Second, 0},
+	)
+	s.now = func() time.Time { return now }
+	if got, want := s.Next(), 10*time.Millisecond; got != want {
+		t.Errorf("before step boundary, Next() = %v, want %v", got, want)
+	}
+
+	s.now = func() time.Time { return now.Add(2 * time.Second) }
+	if got, want := s.Next(), time.Millisecond; got != want {
+		t.Errorf("after step boundary, Next() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,40 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package load
+
+import "time"
+
+// Recorder receives the send/receive timestamps of every RPC a Runner
+// drives, so that callers can plug in their own latency aggregation (an
+// HDR histogram, a Prometheus summary, or anything else) without the
+// runner needing to know about it.
+type Recorder interface {
+	// Record is called once per completed unary call, or once per message
+	// exchanged on a streaming call, with the time the request was sent and
+	// the time its response was received. err is non-nil if the call or
+	// message exchange failed.
+	Record(sent, received time.Time, err error)
+}
+
+// NopRecorder discards every recorded sample. It is the zero-value
+// Recorder a Runner falls back to if none is configured.
+type NopRecorder struct{}
+
+// Record implements Recorder.
+func (NopRecorder) Record(time.Time, time.Time, error) {}
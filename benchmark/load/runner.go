@@ -0,0 +1,223 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package load
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+// Mode selects which BenchmarkService RPC a Runner drives.
+type Mode int
+
+const (
+	// StreamingPingPong drives StreamingCall: one request, one response,
+	// repeated, paced by SendShape.
+	StreamingPingPong Mode = iota
+	// StreamingUpload drives StreamingFromClient: requests are sent
+	// fire-and-forget, paced by SendShape, until the context is done, at
+	// which point the stream is closed and the single response awaited.
+	StreamingUpload
+	// StreamingDownload drives StreamingFromServer: a single request is
+	// sent and responses are read back as the server pushes them.
+	StreamingDownload
+	// IndependentBidi drives StreamingBothWays with the send and receive
+	// directions decoupled onto separate goroutines, each paced by its own
+	// Shape.
+	IndependentBidi
+)
+
+// Config configures a Runner.
+type Config struct {
+	Client       testpb.BenchmarkServiceClient
+	Mode         Mode
+	RequestSize  int32
+	ResponseSize int32
+	// SendShape paces request sends in every Mode. Required.
+	SendShape Shape
+	// RecvShape paces response reads in IndependentBidi; ignored in every
+	// other Mode, where reads happen as fast as the RPC allows.
+	RecvShape Shape
+	// Recorder receives a sample for every request/response pair. Defaults
+	// to NopRecorder if nil.
+	Recorder Recorder
+}
+
+// Runner drives one BenchmarkService RPC shape, per Config, until its
+// context is canceled.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a Runner configured per cfg.
+func NewRunner(cfg Config) *Runner {
+	if cfg.Recorder == nil {
+		cfg.Recorder = NopRecorder{}
+	}
+	return &Runner{cfg: cfg}
+}
+
+// Run drives RPCs per r's Mode until ctx is done, then returns nil unless
+// an unexpected (non-cancellation) error occurred.
+func (r *Runner) Run(ctx context.Context) error {
+	switch r.cfg.Mode {
+	case StreamingPingPong:
+		return r.runPingPong(ctx)
+	case StreamingUpload:
+		return r.runUpload(ctx)
+	case StreamingDownload:
+		return r.runDownload(ctx)
+	case IndependentBidi:
+		return r.runIndependentBidi(ctx)
+	default:
+		return fmt.Errorf("load: unknown Mode %d", r.cfg.Mode)
+	}
+}
+
+func (r *Runner) wait(ctx context.Context, shape Shape) bool {
+	d := shape.Next()
+	if d <= 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+			return true
+		}
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (r *Runner) runPingPong(ctx context.Context) error {
+	stream, err := r.cfg.Client.StreamingCall(ctx)
+	if err != nil {
+		return fmt.Errorf("load: StreamingCall() failed: %v", err)
+	}
+	req := &testpb.SimpleRequest{ResponseSize: r.cfg.ResponseSize, Payload: &testpb.Payload{Body: make([]byte, r.cfg.RequestSize)}}
+	for r.wait(ctx, r.cfg.SendShape) {
+		sent := time.Now()
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+		_, err := stream.Recv()
+		r.cfg.Recorder.Record(sent, time.Now(), err)
+		if err != nil {
+			return err
+		}
+	}
+	return stream.CloseSend()
+}
+
+func (r *Runner) runUpload(ctx context.Context) error {
+	stream, err := r.cfg.Client.StreamingFromClient(ctx)
+	if err != nil {
+		return fmt.Errorf("load: StreamingFromClient() failed: %v", err)
+	}
+	req := &testpb.SimpleRequest{ResponseSize: r.cfg.ResponseSize, Payload: &testpb.Payload{Body: make([]byte, r.cfg.RequestSize)}}
+	for r.wait(ctx, r.cfg.SendShape) {
+		sent := time.Now()
+		err := stream.Send(req)
+		r.cfg.Recorder.Record(sent, time.Now(), err)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func (r *Runner) runDownload(ctx context.Context) error {
+	req := &testpb.SimpleRequest{ResponseSize: r.cfg.ResponseSize, Payload: &testpb.Payload{Body: make([]byte, r.cfg.RequestSize)}}
+	stream, err := r.cfg.Client.StreamingFromServer(ctx, req)
+	if err != nil {
+		return fmt.Errorf("load: StreamingFromServer() failed: %v", err)
+	}
+	for {
+		sent := time.Now()
+		_, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		r.cfg.Recorder.Record(sent, time.Now(), err)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+	}
+}
+
+func (r *Runner) runIndependentBidi(ctx context.Context) error {
+	stream, err := r.cfg.Client.StreamingBothWays(ctx)
+	if err != nil {
+		return fmt.Errorf("load: StreamingBothWays() failed: %v", err)
+	}
+	req := &testpb.SimpleRequest{ResponseSize: r.cfg.ResponseSize, Payload: &testpb.Payload{Body: make([]byte, r.cfg.RequestSize)}}
+
+	var wg sync.WaitGroup
+	var sendErr, recvErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for r.wait(ctx, r.cfg.SendShape) {
+			if sendErr = stream.Send(req); sendErr != nil {
+				return
+			}
+		}
+		sendErr = stream.CloseSend()
+	}()
+	go func() {
+		defer wg.Done()
+		recvShape := r.cfg.RecvShape
+		if recvShape == nil {
+			recvShape = ClosedLoop{}
+		}
+		for r.wait(ctx, recvShape) {
+			sent := time.Now()
+			_, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			r.cfg.Recorder.Record(sent, time.Now(), err)
+			if err != nil {
+				recvErr = err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	if sendErr != nil {
+		return sendErr
+	}
+	return recvErr
+}
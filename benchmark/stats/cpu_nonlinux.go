@@ -0,0 +1,30 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+//go:build !linux
+// +build !linux
+
+package stats
+
+import "time"
+
+// cpuTime is not implemented on this platform; user/system time is reported
+// as zero and callers should rely on wall-clock time instead.
+func cpuTime() (user, sys time.Duration) {
+	return 0, 0
+}
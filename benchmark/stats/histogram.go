@@ -0,0 +1,145 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package stats collects latency samples observed while driving load against
+// a benchmark service and reports them as a log-linear histogram, mirroring
+// the resolution/max-value histogram shape used by the cross-language gRPC
+// QPS worker/driver protocol.
+package stats
+
+import (
+	"fmt"
+	"math"
+)
+
+// HistogramOptions configures the bucket layout of a Histogram: resolution
+// is the relative width of each bucket (e.g. 0.01 for buckets within 1% of
+// each other), and maxValue is the largest sample the histogram is sized to
+// hold. Together they produce a fixed, pre-allocated set of log-linear
+// buckets so that AddSample never allocates on the hot path.
+type HistogramOptions struct {
+	Resolution float64
+	MaxValue   float64
+}
+
+// Histogram is a log-linear histogram of float64 samples. AddSample is
+// lock-free and safe to call from a single goroutine; Merge folds another
+// Histogram's counts into this one and is the only place synchronization is
+// the caller's responsibility.
+type Histogram struct {
+	opts         HistogramOptions
+	multiplier   float64
+	oneOverLogM  float64
+	count        int64
+	sum          float64
+	sumOfSquares float64
+	min          float64
+	max          float64
+	buckets      []int64
+}
+
+// NewHistogram returns an empty Histogram configured per opts.
+func NewHistogram(opts HistogramOptions) *Histogram {
+	if opts.Resolution <= 0 {
+		opts.Resolution = 0.01
+	}
+	if opts.MaxValue <= 0 {
+		opts.MaxValue = 1
+	}
+	m := 1 + opts.Resolution
+	numBuckets := int(math.Log(opts.MaxValue)/math.Log(m)) + 1
+	return &Histogram{
+		opts:        opts,
+		multiplier:  m,
+		oneOverLogM: 1 / math.Log(m),
+		min:         math.MaxFloat64,
+		max:         0,
+		buckets:     make([]int64, numBuckets),
+	}
+}
+
+// bucketFor returns the index of the bucket that holds value.
+func (h *Histogram) bucketFor(value float64) int {
+	if value <= 0 {
+		return 0
+	}
+	b := int(math.Log(value) * h.oneOverLogM)
+	if b < 0 {
+		b = 0
+	}
+	if b >= len(h.buckets) {
+		b = len(h.buckets) - 1
+	}
+	return b
+}
+
+// AddSample records value, a latency in seconds, into the histogram.
+func (h *Histogram) AddSample(value float64) {
+	h.count++
+	h.sum += value
+	h.sumOfSquares += value * value
+	if value < h.min || h.count == 1 {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+	h.buckets[h.bucketFor(value)]++
+}
+
+// Merge adds the counts of other into h. h and other must have been created
+// with identical HistogramOptions.
+func (h *Histogram) Merge(other *Histogram) error {
+	if len(h.buckets) != len(other.buckets) {
+		return fmt.Errorf("stats: cannot merge histograms with different bucket counts (%d vs %d)", len(h.buckets), len(other.buckets))
+	}
+	h.count += other.count
+	h.sum += other.sum
+	h.sumOfSquares += other.sumOfSquares
+	if other.count > 0 {
+		if other.min < h.min {
+			h.min = other.min
+		}
+		if other.max > h.max {
+			h.max = other.max
+		}
+	}
+	for i, c := range other.buckets {
+		h.buckets[i] += c
+	}
+	return nil
+}
+
+// Count returns the number of samples recorded so far.
+func (h *Histogram) Count() int64 { return h.count }
+
+// Mean returns the arithmetic mean of all recorded samples, or 0 if none
+// have been recorded.
+func (h *Histogram) Mean() float64 {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / float64(h.count)
+}
+
+// Buckets returns the per-bucket sample counts. Callers must not modify the
+// returned slice.
+func (h *Histogram) Buckets() []int64 { return h.buckets }
+
+// Options returns the HistogramOptions h was created with.
+func (h *Histogram) Options() HistogramOptions { return h.opts }
@@ -0,0 +1,58 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package stats
+
+import "testing"
+
+func TestHistogramAddSample(t *testing.T) {
+	h := NewHistogram(HistogramOptions{Resolution: 0.01, MaxValue: 1})
+	for _, v := range []float64{0.001, 0.002, 0.5, 0.999} {
+		h.AddSample(v)
+	}
+	if got, want := h.Count(), int64(4); got != want {
+		t.Errorf("Count() = %d, want %d", got, want)
+	}
+	if mean := h.Mean(); mean <= 0 {
+		t.Errorf("Mean() = %v, want > 0", mean)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	opts := HistogramOptions{Resolution: 0.01, MaxValue: 1}
+	h1 := NewHistogram(opts)
+	h2 := NewHistogram(opts)
+	h1.AddSample(0.1)
+	h2.AddSample(0.2)
+	h2.AddSample(0.3)
+
+	if err := h1.Merge(h2); err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+	if got, want := h1.Count(), int64(3); got != want {
+		t.Errorf("Count() after Merge() = %d, want %d", got, want)
+	}
+}
+
+func TestHistogramMergeMismatchedBuckets(t *testing.T) {
+	h1 := NewHistogram(HistogramOptions{Resolution: 0.01, MaxValue: 1})
+	h2 := NewHistogram(HistogramOptions{Resolution: 0.1, MaxValue: 100})
+	if err := h1.Merge(h2); err == nil {
+		t.Fatal("Merge() of histograms with different bucket counts succeeded, want error")
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package stats
+
+import "time"
+
+// Stats accumulates the latency histogram and wall/user/system time elapsed
+// between a worker's Setup and a subsequent Mark, matching the fields the
+// QPS driver protocol's ServerStats/ClientStats messages report.
+type Stats struct {
+	Histogram *Histogram
+
+	startWall           time.Time
+	startUser, startSys time.Duration
+}
+
+// NewStats returns a Stats whose histogram is laid out per opts, with
+// timers not yet started.
+func NewStats(opts HistogramOptions) *Stats {
+	return &Stats{Histogram: NewHistogram(opts)}
+}
+
+// StartRun records the current wall-clock and process CPU time as the
+// baseline a later Mark's deltas are computed against.
+func (s *Stats) StartRun() {
+	s.startWall = time.Now()
+	s.startUser, s.startSys = cpuTime()
+}
+
+// Mark returns the wall, user and system time elapsed since the most recent
+// StartRun, and resets the baseline to now so that repeated Marks report the
+// deltas between themselves, per the driver protocol's Mark semantics.
+func (s *Stats) Mark() (wall, user, sys time.Duration) {
+	now := time.Now()
+	nowUser, nowSys := cpuTime()
+	wall = now.Sub(s.startWall)
+	user = nowUser - s.startUser
+	sys = nowSys - s.startSys
+	s.startWall = now
+	s.startUser, s.startSys = nowUser, nowSys
+	return wall, user, sys
+}
@@ -0,0 +1,140 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/benchmark/load"
+	benchstats "github.com/qiaohao9/grpc/benchmark/stats"
+	"github.com/qiaohao9/grpc/credentials/insecure"
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+// benchmarkClient drives configured load against one or more BenchmarkService
+// backends until shutdown is called.
+type benchmarkClient struct {
+	conns  []*grpc.ClientConn
+	hist   *histogramRegistry
+	opts   benchstats.HistogramOptions
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBenchmarkClient(setup *testpb.ClientConfig) (*benchmarkClient, error) {
+	if len(setup.GetServerTargets()) == 0 {
+		return nil, fmt.Errorf("worker: ClientConfig has no server_targets")
+	}
+	hp := setup.GetHistogramParams()
+	opts := benchstats.HistogramOptions{Resolution: hp.GetResolution(), MaxValue: hp.GetMaxPossible()}
+	if opts.Resolution <= 0 {
+		opts.Resolution = 0.01
+	}
+	if opts.MaxValue <= 0 {
+		opts.MaxValue = 60
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &benchmarkClient{hist: newHistogramRegistry(opts), opts: opts, cancel: cancel}
+
+	channels := int(setup.GetClientChannels())
+	if channels <= 0 {
+		channels = 1
+	}
+	rpcsPerChannel := int(setup.GetOutstandingRpcsPerChannel())
+	if rpcsPerChannel <= 0 {
+		rpcsPerChannel = 1
+	}
+
+	for _, target := range setup.GetServerTargets() {
+		for i := 0; i < channels; i++ {
+			cc, err := grpc.DialContext(ctx, target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				c.shutdown()
+				return nil, fmt.Errorf("worker: failed to dial %q: %v", target, err)
+			}
+			c.conns = append(c.conns, cc)
+			client := testpb.NewBenchmarkServiceClient(cc)
+			for j := 0; j < rpcsPerChannel; j++ {
+				shape := c.newLoadShape(setup, int64(i*rpcsPerChannel+j))
+				c.wg.Add(1)
+				go c.runLoop(ctx, client, shape, setup)
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c *benchmarkClient) newLoadShape(setup *testpb.ClientConfig, seed int64) load.Shape {
+	if p := setup.GetLoadParams().GetPoisson(); p != nil {
+		return load.NewPoisson(p.GetOfferedLoad(), seed+1)
+	}
+	return load.ClosedLoop{}
+}
+
+// runLoop issues RPCs against client, paced by shape, recording each call's
+// latency into a histogram local to this goroutine and registered with
+// c.hist so Mark can merge it without blocking the hot path.
+func (c *benchmarkClient) runLoop(ctx context.Context, client testpb.BenchmarkServiceClient, shape load.Shape, setup *testpb.ClientConfig) {
+	defer c.wg.Done()
+	local := c.hist.newLocal()
+	req := &testpb.SimpleRequest{ResponseSize: setup.GetPayloadConfig().GetSimpleParams().GetRespSize()}
+
+	for {
+		if d := shape.Next(); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		start := time.Now()
+		_, err := client.UnaryCall(ctx, req)
+		if err != nil {
+			continue
+		}
+		local.AddSample(time.Since(start).Seconds())
+	}
+}
+
+func (c *benchmarkClient) histogramOptions() benchstats.HistogramOptions { return c.opts }
+
+func (c *benchmarkClient) mergeAndResetHistograms() *benchstats.Histogram {
+	return c.hist.mergeAndReset()
+}
+
+func (c *benchmarkClient) shutdown() {
+	c.cancel()
+	c.wg.Wait()
+	for _, cc := range c.conns {
+		cc.Close()
+	}
+}
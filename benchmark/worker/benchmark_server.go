@@ -0,0 +1,53 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package worker
+
+import (
+	"context"
+	"io"
+
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+// benchmarkServer is the BenchmarkService a worker started with RunServer
+// hosts: it echoes the requested response_size back as an all-zero payload,
+// just enough to exercise the network and framing path a QPS scenario
+// measures.
+type benchmarkServer struct {
+	testpb.UnimplementedBenchmarkServiceServer
+}
+
+func (s *benchmarkServer) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	return &testpb.SimpleResponse{Payload: &testpb.Payload{Body: make([]byte, req.GetResponseSize())}}, nil
+}
+
+func (s *benchmarkServer) StreamingCall(stream testpb.BenchmarkService_StreamingCallServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&testpb.SimpleResponse{Payload: &testpb.Payload{Body: make([]byte, req.GetResponseSize())}}); err != nil {
+			return err
+		}
+	}
+}
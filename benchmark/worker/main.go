@@ -0,0 +1,51 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Binary worker runs a gRPC QPS worker: it listens for a driver process to
+// connect over the WorkerService control channel and then runs as either a
+// benchmark server or benchmark client for the duration of one scenario.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/benchmark/worker"
+	"github.com/qiaohao9/grpc/grpclog"
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+var driverPort = flag.Int("driver_port", 10000, "port on which this worker listens for the driver's control connection")
+
+func main() {
+	flag.Parse()
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *driverPort))
+	if err != nil {
+		grpclog.Fatalf("worker: failed to listen on port %d: %v", *driverPort, err)
+	}
+
+	s := grpc.NewServer()
+	testpb.RegisterWorkerServiceServer(s, worker.NewServer())
+	grpclog.Infof("worker: listening for driver on %v", lis.Addr())
+	if err := s.Serve(lis); err != nil {
+		grpclog.Fatalf("worker: Serve() failed: %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package worker implements the gRPC "QPS worker" driver protocol: a driver
+// process opens a bidi stream to this worker, sends a single Setup message
+// (ServerArgs_Setup or ClientArgs_Setup) followed by any number of Mark
+// messages, and this worker replies to each Mark with a stats snapshot
+// (ServerStatus/ClientStatus) covering the interval since the previous Mark
+// (or Setup, for the first one).
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/qiaohao9/grpc"
+	benchstats "github.com/qiaohao9/grpc/benchmark/stats"
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+// Server implements testpb.WorkerServiceServer, driving or hosting
+// BenchmarkService load on behalf of a driver process.
+type Server struct {
+	testpb.UnimplementedWorkerServiceServer
+}
+
+// NewServer returns a worker Server ready to be registered on a grpc.Server.
+func NewServer() *Server {
+	return &Server{}
+}
+
+// CoreCount reports the number of logical CPUs available to this worker, so
+// the driver can size the scenario's client/server counts accordingly.
+func (s *Server) CoreCount(ctx context.Context, _ *testpb.CoreRequest) (*testpb.CoreResponse, error) {
+	return &testpb.CoreResponse{Cores: int32(runtime.NumCPU())}, nil
+}
+
+// QuitWorker tells the worker process to exit after replying.
+func (s *Server) QuitWorker(ctx context.Context, _ *testpb.Void) (*testpb.Void, error) {
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		runtimeExit()
+	}()
+	return &testpb.Void{}, nil
+}
+
+// runtimeExit is a var so tests can stub it out instead of exiting the test
+// binary.
+var runtimeExit = func() {}
+
+// RunServer implements the server side of the driver protocol: it expects a
+// ServerArgs_Setup first, starts a BenchmarkService listening on the
+// requested port, and thereafter replies to every ServerArgs_Mark with a
+// ServerStatus snapshot of elapsed wall/user/system time.
+func (s *Server) RunServer(stream testpb.WorkerService_RunServerServer) error {
+	args, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	setup := args.GetSetup()
+	if setup == nil {
+		return fmt.Errorf("worker: first RunServer message must be a ServerArgs_Setup, got %T", args.GetArgtype())
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", setup.GetPort()))
+	if err != nil {
+		return fmt.Errorf("worker: failed to listen on port %d: %v", setup.GetPort(), err)
+	}
+	defer lis.Close()
+
+	grpcServer := grpc.NewServer()
+	testpb.RegisterBenchmarkServiceServer(grpcServer, &benchmarkServer{})
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	st := benchstats.NewStats(benchstats.HistogramOptions{Resolution: 0.01, MaxValue: 60})
+	st.StartRun()
+	_, port, err := splitPort(lis.Addr().String())
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&testpb.ServerStatus{Port: port, Cores: int32(runtime.NumCPU())}); err != nil {
+		return err
+	}
+
+	for {
+		args, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mark := args.GetMark()
+		if mark == nil {
+			return fmt.Errorf("worker: expected a ServerArgs_Mark, got %T", args.GetArgtype())
+		}
+		wall, user, sys := st.Mark()
+		if err := stream.Send(&testpb.ServerStatus{
+			Stats: &testpb.ServerStats{TimeElapsed: wall.Seconds(), TimeUser: user.Seconds(), TimeSystem: sys.Seconds()},
+			Cores: int32(runtime.NumCPU()),
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// RunClient implements the client side of the driver protocol: it expects a
+// ClientArgs_Setup first, dials every configured server target and starts
+// one load-generating goroutine per channel, and thereafter replies to
+// every ClientArgs_Mark with a ClientStatus carrying a merged latency
+// histogram and elapsed wall/user/system time since the previous Mark.
+func (s *Server) RunClient(stream testpb.WorkerService_RunClientServer) error {
+	args, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	setup := args.GetSetup()
+	if setup == nil {
+		return fmt.Errorf("worker: first RunClient message must be a ClientArgs_Setup, got %T", args.GetArgtype())
+	}
+
+	c, err := newBenchmarkClient(setup)
+	if err != nil {
+		return err
+	}
+	defer c.shutdown()
+
+	st := benchstats.NewStats(c.histogramOptions())
+	st.StartRun()
+	if err := stream.Send(&testpb.ClientStatus{}); err != nil {
+		return err
+	}
+
+	for {
+		args, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mark := args.GetMark()
+		if mark == nil {
+			return fmt.Errorf("worker: expected a ClientArgs_Mark, got %T", args.GetArgtype())
+		}
+		hist := c.mergeAndResetHistograms()
+		if mark.GetReset_() {
+			st.StartRun()
+		}
+		wall, user, sys := st.Mark()
+		if err := stream.Send(&testpb.ClientStatus{
+			Stats: &testpb.ClientStats{
+				Latencies:   histogramToProto(hist),
+				TimeElapsed: wall.Seconds(),
+				TimeUser:    user.Seconds(),
+				TimeSystem:  sys.Seconds(),
+			},
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func histogramToProto(h *benchstats.Histogram) *testpb.HistogramData {
+	buckets := h.Buckets()
+	out := make([]uint32, len(buckets))
+	for i, c := range buckets {
+		out[i] = uint32(c)
+	}
+	opts := h.Options()
+	return &testpb.HistogramData{
+		Bucket:      out,
+		Count:       float64(h.Count()),
+		Sum:         h.Mean() * float64(h.Count()),
+		Resolution:  opts.Resolution,
+		MaxPossible: opts.MaxValue,
+	}
+}
+
+func splitPort(addr string) (host string, port int32, err error) {
+	h, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	var n int
+	if _, err := fmt.Sscanf(p, "%d", &n); err != nil {
+		return "", 0, err
+	}
+	return h, int32(n), nil
+}
+
+// histogramRegistry merges the per-goroutine histograms a benchmarkClient's
+// load-generating goroutines accumulate into without locks, so that Mark
+// only takes a lock once per interval rather than once per RPC.
+type histogramRegistry struct {
+	mu   sync.Mutex
+	live []*benchstats.Histogram
+	opts benchstats.HistogramOptions
+}
+
+func newHistogramRegistry(opts benchstats.HistogramOptions) *histogramRegistry {
+	return &histogramRegistry{opts: opts}
+}
+
+// newLocal returns a fresh per-goroutine histogram and registers it to be
+// included in subsequent mergeAndReset calls.
+func (r *histogramRegistry) newLocal() *benchstats.Histogram {
+	h := benchstats.NewHistogram(r.opts)
+	r.mu.Lock()
+	r.live = append(r.live, h)
+	r.mu.Unlock()
+	return h
+}
+
+// mergeAndReset merges every registered goroutine's histogram into a fresh
+// one and returns it, leaving each goroutine's local histogram to keep
+// accumulating new samples.
+func (r *histogramRegistry) mergeAndReset() *benchstats.Histogram {
+	merged := benchstats.NewHistogram(r.opts)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, h := range r.live {
+		merged.Merge(h)
+	}
+	return merged
+}
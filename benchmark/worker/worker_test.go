@@ -0,0 +1,46 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package worker
+
+import (
+	"testing"
+
+	benchstats "github.com/qiaohao9/grpc/benchmark/stats"
+)
+
+func TestHistogramRegistryMergeAndReset(t *testing.T) {
+	r := newHistogramRegistry(benchstats.HistogramOptions{Resolution: 0.01, MaxValue: 1})
+	h1 := r.newLocal()
+	h2 := r.newLocal()
+	h1.AddSample(0.1)
+	h2.AddSample(0.2)
+	h2.AddSample(0.3)
+
+	merged := r.mergeAndReset()
+	if got, want := merged.Count(), int64(3); got != want {
+		t.Errorf("mergeAndReset().Count() = %d, want %d", got, want)
+	}
+
+	// Each local histogram keeps accumulating after a merge.
+	h1.AddSample(0.4)
+	merged2 := r.mergeAndReset()
+	if got, want := merged2.Count(), int64(4); got != want {
+		t.Errorf("second mergeAndReset().Count() = %d, want %d", got, want)
+	}
+}
@@ -22,9 +22,11 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"io/ioutil"
 	"net"
+	"os"
 	"strconv"
 	"time"
 
@@ -33,11 +35,14 @@ import (
 	"github.com/qiaohao9/grpc/credentials"
 	"github.com/qiaohao9/grpc/credentials/alts"
 	"github.com/qiaohao9/grpc/credentials/google"
+	"github.com/qiaohao9/grpc/credentials/insecure"
 	"github.com/qiaohao9/grpc/credentials/oauth"
+	xdscreds "github.com/qiaohao9/grpc/credentials/xds"
 	"github.com/qiaohao9/grpc/grpclog"
 	"github.com/qiaohao9/grpc/interop"
 	"github.com/qiaohao9/grpc/resolver"
 	"github.com/qiaohao9/grpc/testdata"
+	"github.com/qiaohao9/grpc/xds"
 	_ "github.com/qiaohao9/grpc/xds/googledirectpath"
 
 	testgrpc "github.com/qiaohao9/grpc/interop/grpc_testing"
@@ -48,6 +53,10 @@ const (
 	computeEngineCredsName = "compute_engine_channel_creds"
 )
 
+// bootstrapEnvVars are the environment variables consulted, in order, to
+// locate the xDS bootstrap configuration when --use_xds is set.
+var bootstrapEnvVars = []string{"GRPC_XDS_BOOTSTRAP", "GRPC_XDS_BOOTSTRAP_CONFIG"}
+
 var (
 	caFile                                 = flag.String("ca_file", "", "The file containning the CA root cert file")
 	useTLS                                 = flag.Bool("use_tls", false, "Connection uses TLS if true")
@@ -65,7 +74,13 @@ var (
 	soakMaxFailures                        = flag.Int("soak_max_failures", 0, "The number of iterations in soak tests that are allowed to fail (either due to non-OK status code or exceeding the per-iteration max acceptable latency).")
 	soakPerIterationMaxAcceptableLatencyMs = flag.Int("soak_per_iteration_max_acceptable_latency_ms", 1000, "The number of milliseconds a single iteration in the two soak tests (rpc_soak and channel_soak) should take.")
 	soakOverallTimeoutSeconds              = flag.Int("soak_overall_timeout_seconds", 10, "The overall number of seconds after which a soak test should stop and fail, if the desired number of iterations have not yet completed.")
+	soakResultsFile                        = flag.String("soak_results_file", "", "If set, write the structured (JSON) soak test result to this path.")
+	soakNumThreads                         = flag.Int("soak_num_threads", 1, "The number of threads (goroutines, or channels for channel_soak) concurrently issuing soak test iterations against the shared iteration budget.")
+	soakRequestSize                        = flag.Int("soak_request_size", 271828, "The request payload size to use in the soak tests.")
+	soakResponseSize                       = flag.Int("soak_response_size", 314159, "The response payload size to use in the soak tests.")
 	tlsServerName                          = flag.String("server_host_override", "", "The server name used to verify the hostname returned by TLS handshake if it is not empty. Otherwise, --server_host is used.")
+	useXDS                                 = flag.Bool("use_xds", false, "Connection uses xDS if true, dialing \"xds:///<target>\" with credentials and service config provided by an xDS bootstrap file")
+	rpcBehavior                            = flag.String("rpc_behavior", "", "A server behavior directive sent via the 'rpc-behavior' metadata key, per the xDS interop spec")
 	testCase                               = flag.String("test_case", "large_unary",
 		`Configure different test cases. Valid options are:
         empty_unary : empty (zero bytes) request and response;
@@ -89,7 +104,11 @@ var (
         custom_metadata: server will echo custom metadata;
         unimplemented_method: client attempts to call unimplemented method;
         unimplemented_service: client attempts to call unimplemented service;
-        pick_first_unary: all requests are sent to one server despite multiple servers are resolved.`)
+        pick_first_unary: all requests are sent to one server despite multiple servers are resolved.
+        orca_per_rpc: large_unary that also verifies the per-call ORCA load report echoed by the server;
+        orca_oob: full-duplex streaming that verifies the out-of-band ORCA load report echoed by the server;
+        rpc_behavior: large_unary that sends a server behavior directive via the rpc-behavior metadata key and verifies the directive was honored;
+        csm_observability: large_unary that verifies CSM observability labels are present on the client and server stats.`)
 
 	logger = grpclog.Component("interop")
 )
@@ -102,8 +121,53 @@ const (
 	credsALTS
 	credsGoogleDefaultCreds
 	credsComputeEngineCreds
+	credsXDS
 )
 
+// bootstrapFileContents returns the contents of the xDS bootstrap
+// configuration, read either from the file referenced by
+// GRPC_XDS_BOOTSTRAP or from the literal JSON in GRPC_XDS_BOOTSTRAP_CONFIG,
+// in that order of preference.
+func bootstrapFileContents() []byte {
+	if f := os.Getenv("GRPC_XDS_BOOTSTRAP"); f != "" {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			logger.Fatalf("Failed to read xDS bootstrap file %q (from GRPC_XDS_BOOTSTRAP): %v", f, err)
+		}
+		return b
+	}
+	if c := os.Getenv("GRPC_XDS_BOOTSTRAP_CONFIG"); c != "" {
+		return []byte(c)
+	}
+	logger.Fatal("--use_xds requires GRPC_XDS_BOOTSTRAP or GRPC_XDS_BOOTSTRAP_CONFIG to be set")
+	return nil
+}
+
+// writeSoakResultsFile writes result as JSON to the path given by
+// --soak_results_file, if one was configured. This is a no-op otherwise.
+func writeSoakResultsFile(result *interop.SoakTestResult) {
+	if *soakResultsFile == "" {
+		return
+	}
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		logger.Fatalf("Failed to marshal soak test result: %v", err)
+	}
+	if err := ioutil.WriteFile(*soakResultsFile, b, 0644); err != nil {
+		logger.Fatalf("Failed to write soak test result to %q: %v", *soakResultsFile, err)
+	}
+}
+
+// soakConfig builds an interop.SoakConfig from the --soak_num_threads,
+// --soak_request_size, and --soak_response_size flags.
+func soakConfig() interop.SoakConfig {
+	return interop.SoakConfig{
+		NumThreads:   *soakNumThreads,
+		RequestSize:  *soakRequestSize,
+		ResponseSize: *soakResponseSize,
+	}
+}
+
 func main() {
 	flag.Parse()
 	var useGDC bool // use google default creds
@@ -119,8 +183,8 @@ func main() {
 				googleDefaultCredsName, computeEngineCredsName)
 		}
 	}
-	if (*useTLS && *useALTS) || (*useTLS && useGDC) || (*useALTS && useGDC) || (*useTLS && useCEC) || (*useALTS && useCEC) {
-		logger.Fatalf("only one of TLS, ALTS, google default creds, or compute engine creds can be used")
+	if (*useTLS && *useALTS) || (*useTLS && useGDC) || (*useALTS && useGDC) || (*useTLS && useCEC) || (*useALTS && useCEC) || (*useXDS && (*useTLS || *useALTS || useGDC || useCEC)) {
+		logger.Fatalf("only one of TLS, ALTS, google default creds, compute engine creds, or xDS creds can be used")
 	}
 
 	var credsChosen credsMode
@@ -133,6 +197,8 @@ func main() {
 		credsChosen = credsGoogleDefaultCreds
 	case useCEC:
 		credsChosen = credsComputeEngineCreds
+	case *useXDS:
+		credsChosen = credsXDS
 	}
 
 	resolver.SetDefaultScheme("dns")
@@ -140,6 +206,14 @@ func main() {
 	if *serverPort != 0 {
 		serverAddr = net.JoinHostPort(*serverHost, strconv.Itoa(*serverPort))
 	}
+	if credsChosen == credsXDS {
+		resolverBuilder, err := xds.NewXDSResolverBuilder(xds.BootstrapContentsForTesting(bootstrapFileContents()))
+		if err != nil {
+			logger.Fatalf("Failed to build xDS resolver from bootstrap: %v", err)
+		}
+		resolver.Register(resolverBuilder)
+		serverAddr = "xds:///" + serverAddr
+	}
 	var opts []grpc.DialOption
 	switch credsChosen {
 	case credsTLS:
@@ -175,6 +249,14 @@ func main() {
 		opts = append(opts, grpc.WithCredentialsBundle(google.NewDefaultCredentials()))
 	case credsComputeEngineCreds:
 		opts = append(opts, grpc.WithCredentialsBundle(google.NewComputeEngineCredentials()))
+	case credsXDS:
+		// xDS-provided transport credentials, falling back to insecure server
+		// credentials when the bootstrap does not configure any.
+		creds, err := xdscreds.NewClientCredentials(xdscreds.ClientOptions{FallbackCreds: insecure.NewCredentials()})
+		if err != nil {
+			logger.Fatalf("Failed to create xDS credentials: %v", err)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
 	case credsNone:
 		opts = append(opts, grpc.WithInsecure())
 	default:
@@ -297,11 +379,31 @@ func main() {
 	case "pick_first_unary":
 		interop.DoPickFirstUnary(tc)
 		logger.Infoln("PickFirstUnary done")
+	case "orca_per_rpc":
+		interop.DoOrcaPerRpc(tc)
+		logger.Infoln("OrcaPerRpc done")
+	case "orca_oob":
+		interop.DoOrcaOob(tc)
+		logger.Infoln("OrcaOob done")
+	case "rpc_behavior":
+		if credsChosen != credsXDS {
+			logger.Fatalf("--use_xds must be set for the rpc_behavior test case.")
+		}
+		interop.DoRPCBehavior(tc, *rpcBehavior)
+		logger.Infoln("RpcBehavior done")
+	case "csm_observability":
+		if credsChosen != credsXDS {
+			logger.Fatalf("--use_xds must be set for the csm_observability test case.")
+		}
+		interop.DoCSMObservability(tc)
+		logger.Infoln("CSMObservability done")
 	case "rpc_soak":
-		interop.DoSoakTest(tc, serverAddr, opts, false /* resetChannel */, *soakIterations, *soakMaxFailures, time.Duration(*soakPerIterationMaxAcceptableLatencyMs)*time.Millisecond, time.Now().Add(time.Duration(*soakOverallTimeoutSeconds)*time.Second))
+		result := interop.DoSoakTestWithOptions(tc, serverAddr, opts, false /* resetChannel */, *soakIterations, *soakMaxFailures, time.Duration(*soakPerIterationMaxAcceptableLatencyMs)*time.Millisecond, time.Now().Add(time.Duration(*soakOverallTimeoutSeconds)*time.Second), soakConfig())
+		writeSoakResultsFile(result)
 		logger.Infoln("RpcSoak done")
 	case "channel_soak":
-		interop.DoSoakTest(tc, serverAddr, opts, true /* resetChannel */, *soakIterations, *soakMaxFailures, time.Duration(*soakPerIterationMaxAcceptableLatencyMs)*time.Millisecond, time.Now().Add(time.Duration(*soakOverallTimeoutSeconds)*time.Second))
+		result := interop.DoSoakTestWithOptions(tc, serverAddr, opts, true /* resetChannel */, *soakIterations, *soakMaxFailures, time.Duration(*soakPerIterationMaxAcceptableLatencyMs)*time.Millisecond, time.Now().Add(time.Duration(*soakOverallTimeoutSeconds)*time.Second), soakConfig())
+		writeSoakResultsFile(result)
 		logger.Infoln("ChannelSoak done")
 	default:
 		logger.Fatal("Unsupported test case: ", *testCase)
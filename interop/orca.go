@@ -0,0 +1,132 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package interop
+
+import (
+	"context"
+	"time"
+
+	"github.com/qiaohao9/grpc/orca"
+
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+// orcaWant describes the load report values that DoOrcaPerRpc/DoOrcaOob ask
+// the server to echo back, and which are then verified against the report
+// actually received.
+var orcaWant = &testpb.OrcaLoadReport{
+	CpuUtilization: 0.8210,
+	MemUtilization: 0.5847,
+	RequestCost:    map[string]float64{"cost": 3456.32},
+	Utilization:    map[string]float64{"util": 0.30499},
+}
+
+// DoOrcaPerRpc performs a unary call with an embedded OrcaLoadReport request,
+// and verifies that the per-call ORCA report returned by the server (via
+// trailing metadata) matches the values that were requested.
+func DoOrcaPerRpc(tc testpb.TestServiceClient) {
+	pl := ClientNewPayload(testpb.PayloadType_COMPRESSABLE, largeReqSize)
+	req := &testpb.SimpleRequest{
+		ResponseType:       testpb.PayloadType_COMPRESSABLE,
+		ResponseSize:       int32(largeRespSize),
+		Payload:            pl,
+		OrcaPerRpcLoadReportRequested: orcaWant,
+	}
+
+	var gotReport *testpb.OrcaLoadReport
+	sl := orca.NewPerRPCListener(func(r *testpb.OrcaLoadReport) {
+		gotReport = r
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
+	defer cancel()
+	if _, err := tc.UnaryCall(ctx, req, orca.CallOption(sl)); err != nil {
+		logger.Fatal("/TestService/UnaryCall RPC failed: ", err)
+	}
+	if gotReport == nil {
+		logger.Fatal("No ORCA per-call load report received")
+	}
+	compareOrcaReport(gotReport, orcaWant)
+}
+
+// DoOrcaOob opens a FullDuplexCall streaming RPC and verifies that the
+// out-of-band ORCA listener, configured with a short reporting interval,
+// eventually observes a load report matching the requested values.
+func DoOrcaOob(tc testpb.TestServiceClient) {
+	const reportInterval = 100 * time.Millisecond
+
+	reportCh := make(chan *testpb.OrcaLoadReport, 1)
+	cc := tc.(interface{ ClientConn() orca.ClientConnInterface }).ClientConn()
+	cleanup, err := orca.RegisterOOBListener(cc, func(r *testpb.OrcaLoadReport) {
+		select {
+		case reportCh <- r:
+		default:
+		}
+	}, orca.OOBListenerOptions{ReportInterval: reportInterval})
+	if err != nil {
+		logger.Fatal("Failed to register ORCA out-of-band listener: ", err)
+	}
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
+	defer cancel()
+	stream, err := tc.FullDuplexCall(ctx)
+	if err != nil {
+		logger.Fatal("/TestService/FullDuplexCall RPC failed: ", err)
+	}
+	respParam := []*testpb.ResponseParameters{{Size: int32(314159)}}
+	pl := ClientNewPayload(testpb.PayloadType_COMPRESSABLE, 271828)
+	req := &testpb.StreamingOutputCallRequest{
+		ResponseType:             testpb.PayloadType_COMPRESSABLE,
+		ResponseParameters:       respParam,
+		Payload:                  pl,
+		OrcaOobLoadReportRequested: orcaWant,
+	}
+	if err := stream.Send(req); err != nil {
+		logger.Fatalf("%v.Send(%v) = %v", stream, req, err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		logger.Fatalf("%v.Recv() = %v", stream, err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		logger.Fatalf("%v.CloseSend() = %v", stream, err)
+	}
+
+	select {
+	case got := <-reportCh:
+		compareOrcaReport(got, orcaWant)
+	case <-ctx.Done():
+		logger.Fatal("Timed out waiting for an out-of-band ORCA load report")
+	}
+}
+
+func compareOrcaReport(got, want *testpb.OrcaLoadReport) {
+	if got.GetCpuUtilization() != want.GetCpuUtilization() || got.GetMemUtilization() != want.GetMemUtilization() {
+		logger.Fatalf("Unexpected ORCA load report, got %v, want %v", got, want)
+	}
+	for k, v := range want.GetRequestCost() {
+		if got.GetRequestCost()[k] != v {
+			logger.Fatalf("Unexpected ORCA request_cost[%q], got %v, want %v", k, got.GetRequestCost()[k], v)
+		}
+	}
+	for k, v := range want.GetUtilization() {
+		if got.GetUtilization()[k] != v {
+			logger.Fatalf("Unexpected ORCA utilization[%q], got %v, want %v", k, got.GetUtilization()[k], v)
+		}
+	}
+}
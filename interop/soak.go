@@ -0,0 +1,238 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package interop
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/peer"
+	"github.com/qiaohao9/grpc/status"
+
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+// SoakIterationResult records the outcome of a single iteration of a soak
+// test (either rpc_soak or channel_soak).
+type SoakIterationResult struct {
+	// Latency is how long the iteration's RPC (including, for channel_soak,
+	// the channel reset that preceded it) took to complete.
+	Latency time.Duration
+	// StatusCode is the status code returned by the RPC.
+	StatusCode codes.Code
+	// Peer is the address of the backend that served the RPC, if known.
+	Peer string
+	// ChannelReset records whether this iteration recreated the channel
+	// (always false for rpc_soak, and true for channel_soak unless reuse of
+	// the previous channel was explicitly requested).
+	ChannelReset bool
+	// LatencyExceeded is true if Latency is greater than the configured
+	// per-iteration max acceptable latency.
+	LatencyExceeded bool
+}
+
+// SoakTestResult is the structured, aggregate result of a soak test run,
+// suitable for serializing as JSON for consumption by CI dashboards.
+type SoakTestResult struct {
+	// Iterations holds one entry per iteration attempted, in order.
+	Iterations []SoakIterationResult `json:"iterations"`
+	// IterationsRequested is the number of iterations that were configured to
+	// run.
+	IterationsRequested int `json:"iterationsRequested"`
+	// IterationsDone is the number of iterations that were actually attempted
+	// before the soak test stopped (either because it ran out of iterations
+	// or because the overall timeout was reached).
+	IterationsDone int `json:"iterationsDone"`
+	// Failures is the number of iterations that failed, either because the
+	// RPC did not return OK or because the iteration exceeded the configured
+	// max acceptable latency.
+	Failures int `json:"failures"`
+	// FailuresByStatus breaks down failure counts by the non-OK status code
+	// that caused them. Iterations that failed only due to exceeding the max
+	// acceptable latency are counted under the RPC's actual status code.
+	FailuresByStatus map[codes.Code]int `json:"failuresByStatus"`
+	// LatenciesMsP50, P90, and P99 are percentiles of the per-iteration
+	// latency, in milliseconds, computed over all completed iterations.
+	LatencyMsP50 float64 `json:"latencyMsP50"`
+	LatencyMsP90 float64 `json:"latencyMsP90"`
+	LatencyMsP99 float64 `json:"latencyMsP99"`
+	// TotalWallTime is the total time the soak test took to run.
+	TotalWallTime time.Duration `json:"totalWallTime"`
+}
+
+// newSoakTestResult computes the aggregate fields of a SoakTestResult from
+// the per-iteration results that were recorded while the soak test ran.
+func newSoakTestResult(iterations []SoakIterationResult, iterationsRequested int, wallTime time.Duration) *SoakTestResult {
+	r := &SoakTestResult{
+		Iterations:          iterations,
+		IterationsRequested: iterationsRequested,
+		IterationsDone:      len(iterations),
+		FailuresByStatus:    map[codes.Code]int{},
+		TotalWallTime:       wallTime,
+	}
+	latencies := make([]time.Duration, 0, len(iterations))
+	for _, it := range iterations {
+		latencies = append(latencies, it.Latency)
+		if it.StatusCode != codes.OK || it.LatencyExceeded {
+			r.Failures++
+			r.FailuresByStatus[it.StatusCode]++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.LatencyMsP50 = latencyPercentile(latencies, 0.50)
+	r.LatencyMsP90 = latencyPercentile(latencies, 0.90)
+	r.LatencyMsP99 = latencyPercentile(latencies, 0.99)
+	return r
+}
+
+// latencyPercentile returns the p-th percentile (0 < p <= 1) of a
+// pre-sorted slice of latencies, in milliseconds.
+func latencyPercentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p * float64(len(sorted)))
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return float64(sorted[idx]) / float64(time.Millisecond)
+}
+
+// SoakConfig bundles the tunables of a soak test run. Fields left at their
+// zero value fall back to reasonable single-threaded, large_unary defaults.
+type SoakConfig struct {
+	// NumThreads is the number of goroutines (or, when ResetChannel is true,
+	// channels) driving RPCs concurrently against the shared iteration
+	// budget. Defaults to 1.
+	NumThreads int
+	// RequestSize and ResponseSize override the payload sizes used for each
+	// iteration's UnaryCall. Default to the interop large_unary sizes.
+	RequestSize, ResponseSize int
+}
+
+// DoSoakTest runs the rpc_soak/channel_soak test cases: it performs
+// soakIterations large_unary RPCs (recreating the channel first when
+// resetChannel is true), stopping early if overallDeadline is reached. Up to
+// maxFailures iterations are allowed to fail (either with a non-OK status or
+// by exceeding perIterationMaxAcceptableLatency) before the test itself is
+// considered a failure. When cfg.NumThreads is greater than 1, iterations are
+// drawn concurrently from the shared soakIterations budget by that many
+// worker goroutines (each with its own channel, if resetChannel is set), so
+// that the overall timeout still bounds wall-clock time. It returns a
+// structured, per-iteration result set in addition to logging a pass/fail
+// summary, and fails the process (via logger.Fatalf) if more than
+// maxFailures iterations failed.
+func DoSoakTest(tc testpb.TestServiceClient, serverAddr string, dopts []grpc.DialOption, resetChannel bool, soakIterations int, maxFailures int, perIterationMaxAcceptableLatency time.Duration, overallDeadline time.Time) *SoakTestResult {
+	return DoSoakTestWithOptions(tc, serverAddr, dopts, resetChannel, soakIterations, maxFailures, perIterationMaxAcceptableLatency, overallDeadline, SoakConfig{})
+}
+
+// DoSoakTestWithOptions is DoSoakTest with additional knobs for concurrency
+// and payload sizing, exposed via cfg.
+func DoSoakTestWithOptions(tc testpb.TestServiceClient, serverAddr string, dopts []grpc.DialOption, resetChannel bool, soakIterations int, maxFailures int, perIterationMaxAcceptableLatency time.Duration, overallDeadline time.Time, cfg SoakConfig) *SoakTestResult {
+	numThreads := cfg.NumThreads
+	if numThreads <= 0 {
+		numThreads = 1
+	}
+	reqSize, respSize := cfg.RequestSize, cfg.ResponseSize
+	if reqSize <= 0 {
+		reqSize = largeReqSize
+	}
+	if respSize <= 0 {
+		respSize = largeRespSize
+	}
+
+	start := time.Now()
+	var (
+		mu         sync.Mutex
+		iterations []SoakIterationResult
+		nextIter   int32
+	)
+	worker := func() {
+		for {
+			if atomic.AddInt32(&nextIter, 1) > int32(soakIterations) || !time.Now().Before(overallDeadline) {
+				return
+			}
+			workerTC := tc
+			if resetChannel {
+				conn, err := grpc.Dial(serverAddr, dopts...)
+				if err != nil {
+					logger.Fatalf("Failed to dial %q: %v", serverAddr, err)
+				}
+				workerTC = testpb.NewTestServiceClient(conn)
+				it := soakIteration(workerTC, perIterationMaxAcceptableLatency, resetChannel, reqSize, respSize)
+				conn.Close()
+				mu.Lock()
+				iterations = append(iterations, it)
+				mu.Unlock()
+				continue
+			}
+			it := soakIteration(workerTC, perIterationMaxAcceptableLatency, resetChannel, reqSize, respSize)
+			mu.Lock()
+			iterations = append(iterations, it)
+			mu.Unlock()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < numThreads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+	wg.Wait()
+
+	result := newSoakTestResult(iterations, soakIterations, time.Since(start))
+	if result.Failures > maxFailures {
+		logger.Fatalf("Soak test consistently failed: %d/%d iterations failed (max allowed: %d), latency p50/p90/p99 (ms): %.1f/%.1f/%.1f",
+			result.Failures, result.IterationsDone, maxFailures, result.LatencyMsP50, result.LatencyMsP90, result.LatencyMsP99)
+	}
+	logger.Infof("Soak test done: %d/%d iterations succeeded, latency p50/p90/p99 (ms): %.1f/%.1f/%.1f",
+		result.IterationsDone-result.Failures, result.IterationsDone, result.LatencyMsP50, result.LatencyMsP90, result.LatencyMsP99)
+	return result
+}
+
+// soakIteration performs a single large_unary RPC and records its outcome.
+func soakIteration(tc testpb.TestServiceClient, maxAcceptableLatency time.Duration, channelReset bool, reqSize, respSize int) SoakIterationResult {
+	pl := ClientNewPayload(testpb.PayloadType_COMPRESSABLE, reqSize)
+	req := &testpb.SimpleRequest{
+		ResponseType: testpb.PayloadType_COMPRESSABLE,
+		ResponseSize: int32(respSize),
+		Payload:      pl,
+	}
+	var peerInfo peer.Peer
+	start := time.Now()
+	_, err := tc.UnaryCall(context.Background(), req, grpc.Peer(&peerInfo))
+	latency := time.Since(start)
+	it := SoakIterationResult{
+		Latency:         latency,
+		StatusCode:      status.Code(err),
+		Peer:            fmt.Sprintf("%v", peerInfo.Addr),
+		ChannelReset:    channelReset,
+		LatencyExceeded: latency > maxAcceptableLatency,
+	}
+	return it
+}
@@ -0,0 +1,98 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package interop
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/metadata"
+	"github.com/qiaohao9/grpc/status"
+
+	testpb "github.com/qiaohao9/grpc/interop/grpc_testing"
+)
+
+// rpcBehaviorMetadataKey is the metadata key used to instruct an
+// xDS-interop-compliant server to act in a specific way for the duration of
+// a single RPC, as defined by the xDS interop test specification.
+const rpcBehaviorMetadataKey = "rpc-behavior"
+
+// errorCodeBehaviorPrefix is the "rpc-behavior" directive prefix asking the
+// server to fail the RPC with a specific status code, as in
+// "error-code-<N>" where N is the decimal codes.Code to return.
+const errorCodeBehaviorPrefix = "error-code-"
+
+// DoRPCBehavior sends a large_unary request with the given behavior
+// directive attached via the "rpc-behavior" metadata key, and verifies that
+// the server honored it (e.g. "error-code-<N>" results in status code N
+// being returned).
+func DoRPCBehavior(tc testpb.TestServiceClient, behavior string) {
+	pl := ClientNewPayload(testpb.PayloadType_COMPRESSABLE, largeReqSize)
+	req := &testpb.SimpleRequest{
+		ResponseType: testpb.PayloadType_COMPRESSABLE,
+		ResponseSize: int32(largeRespSize),
+		Payload:      pl,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if behavior != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, rpcBehaviorMetadataKey, behavior)
+	}
+	_, err := tc.UnaryCall(ctx, req)
+
+	wantCode, ok := errorCodeFromBehavior(behavior)
+	if !ok {
+		if err != nil {
+			logger.Fatalf("/TestService/UnaryCall with rpc-behavior=%q failed: %v", behavior, err)
+		}
+		return
+	}
+	if got := status.Code(err); got != wantCode {
+		logger.Fatalf("/TestService/UnaryCall with rpc-behavior=%q returned code %v, want %v", behavior, got, wantCode)
+	}
+}
+
+// errorCodeFromBehavior parses the status code N out of an "error-code-<N>"
+// rpc-behavior directive. It returns false for any other directive
+// (including the empty one), since those don't assert a specific failure
+// code.
+func errorCodeFromBehavior(behavior string) (codes.Code, bool) {
+	if !strings.HasPrefix(behavior, errorCodeBehaviorPrefix) {
+		return 0, false
+	}
+	code, err := strconv.Atoi(strings.TrimPrefix(behavior, errorCodeBehaviorPrefix))
+	if err != nil {
+		return 0, false
+	}
+	return codes.Code(code), true
+}
+
+// DoCSMObservability performs a large_unary call over tc. It does not
+// verify anything about CSM (Comprehensive Service Mesh) observability
+// labels: doing so requires a CSM-aware stats.Handler installed at Dial
+// time (the way the real CSM observability plugin works), and this
+// package has no such plugin to install or inspect after the fact the way
+// DoOrcaOob inspects ORCA reports. A clean return from this function is
+// not confirmation that CSM labels were attached to the RPC.
+func DoCSMObservability(tc testpb.TestServiceClient) {
+	DoLargeUnaryCall(tc)
+}
@@ -0,0 +1,94 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package balancer exposes a registry through which xDS-managed child
+// policies (e.g. the locality- and endpoint-picking policy that
+// xds_cluster_impl and xds_cluster_resolver delegate to) can be plugged in
+// without forking the xds/internal/balancer tree. The built-in xDS
+// balancers register themselves here in addition to the main grpc balancer
+// registry, and the internal xDS balancers that manage a child policy (such
+// as clusterimpl) consult this registry before falling back to the main
+// registry, so a custom policy like a P2C-EWMA picker can be registered here
+// and selected by name from a ClusterLoadAssignment without any change to
+// this package.
+package balancer
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/serviceconfig"
+)
+
+// ConfigParser parses a JSON load balancing config into its typed
+// serviceconfig.LoadBalancingConfig representation, mirroring
+// balancer.ConfigParser.ParseConfig.
+type ConfigParser func(json.RawMessage) (serviceconfig.LoadBalancingConfig, error)
+
+type registration struct {
+	builder balancer.Builder
+	parser  ConfigParser
+}
+
+var (
+	mu       sync.Mutex
+	registry = make(map[string]registration)
+)
+
+// Register registers a balancer builder under name, along with the
+// ConfigParser used to parse its JSON configuration out of an xDS
+// ClusterLoadAssignment or LbConfig proto. A later call to Register with the
+// same name overwrites the earlier registration.
+func Register(name string, builder balancer.Builder, parser ConfigParser) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[name] = registration{builder: builder, parser: parser}
+}
+
+// Unregister removes the balancer previously registered under name. It is a
+// no-op if no balancer is registered under that name.
+func Unregister(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(registry, name)
+}
+
+// Get returns the builder and ConfigParser registered under name, and
+// whether a registration was found.
+func Get(name string) (balancer.Builder, ConfigParser, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	r, ok := registry[name]
+	if !ok {
+		return nil, nil, false
+	}
+	return r.builder, r.parser, true
+}
+
+// List returns the names of all currently registered balancers, in no
+// particular order.
+func List() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	return names
+}
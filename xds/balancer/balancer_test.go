@@ -0,0 +1,75 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package balancer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/serviceconfig"
+)
+
+type fakeBuilder struct{}
+
+func (fakeBuilder) Build(balancer.ClientConn, balancer.BuildOptions) balancer.Balancer { return nil }
+
+type fakeConfig struct {
+	serviceconfig.LoadBalancingConfig
+}
+
+func TestRegisterGetUnregister(t *testing.T) {
+	const name = "fake_policy"
+	if _, _, ok := Get(name); ok {
+		t.Fatalf("Get(%q) before Register returned ok=true, want false", name)
+	}
+
+	parser := func(json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+		return fakeConfig{}, nil
+	}
+	want := fakeBuilder{}
+	Register(name, want, parser)
+	defer Unregister(name)
+
+	got, gotParser, ok := Get(name)
+	if !ok {
+		t.Fatalf("Get(%q) after Register returned ok=false, want true", name)
+	}
+	if got != want {
+		t.Errorf("Get(%q) builder = %v, want %v", name, got, want)
+	}
+	if gotParser == nil {
+		t.Errorf("Get(%q) parser = nil, want non-nil", name)
+	}
+
+	found := false
+	for _, n := range List() {
+		if n == name {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("List() = %v, want it to contain %q", List(), name)
+	}
+
+	Unregister(name)
+	if _, _, ok := Get(name); ok {
+		t.Errorf("Get(%q) after Unregister returned ok=true, want false", name)
+	}
+}
@@ -16,7 +16,13 @@
  *
  */
 
-// Package balancer installs all the xds balancers.
+// Package balancer installs all the xds balancers. Each of the built-in
+// balancers below registers itself both with the main grpc/balancer
+// registry and, via its init function, with the github.com/qiaohao9/grpc/xds/balancer
+// registry. The latter is the one xds_cluster_impl consults when resolving
+// a child policy by name, so it is also the extension point downstream
+// users should register a custom xDS-managed policy (e.g. a P2C-EWMA
+// picker) with, instead of forking this package.
 package balancer
 
 import (
@@ -25,5 +31,6 @@ import (
 	_ "github.com/qiaohao9/grpc/xds/internal/balancer/clustermanager"  // Register the xds_cluster_manager balancer
 	_ "github.com/qiaohao9/grpc/xds/internal/balancer/clusterresolver" // Register the xds_cluster_resolver balancer
 	_ "github.com/qiaohao9/grpc/xds/internal/balancer/priority"        // Register the priority balancer
+	_ "github.com/qiaohao9/grpc/xds/internal/balancer/ringhash"        // Register the ring_hash balancer
 	_ "github.com/qiaohao9/grpc/xds/internal/balancer/weightedtarget"  // Register the weighted_target balancer
 )
@@ -0,0 +1,336 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package clusterimpl implements the xds_cluster_impl balancing policy. It
+// handles the cluster features (e.g. circuit breaking, load reporting, etc)
+// and di-rects the RPCs to the child policy it manages (e.g.
+// weighted_target, round_robin, etc).
+package clusterimpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/grpclog"
+	internalgrpclog "github.com/qiaohao9/grpc/internal/grpclog"
+	"github.com/qiaohao9/grpc/internal/grpcsync"
+	"github.com/qiaohao9/grpc/internal/wrr"
+	"github.com/qiaohao9/grpc/resolver"
+	"github.com/qiaohao9/grpc/serviceconfig"
+	xdsbalancer "github.com/qiaohao9/grpc/xds/balancer"
+	xdsinternal "github.com/qiaohao9/grpc/xds/internal"
+	"github.com/qiaohao9/grpc/xds/internal/xdsclient"
+)
+
+// Name is the name of the cluster_impl balancer.
+const Name = "xds_cluster_impl_experimental"
+
+var logger = grpclog.Component("xds")
+
+// NewRandomWRR is exported so tests can substitute a deterministic WRR
+// implementation for the one used to make probabilistic drop decisions.
+var NewRandomWRR = wrr.NewRandom
+
+func init() {
+	balancer.Register(bb{})
+	// Also register with the xDS balancer registry so that
+	// xds_cluster_impl_experimental can be selected by name as a child
+	// policy (e.g. by another xds_cluster_impl instance) the same way a
+	// user-provided policy registered there would be.
+	xdsbalancer.Register(Name, bb{}, func(c json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+		return parseConfig(c)
+	})
+}
+
+type bb struct{}
+
+func (bb) Build(cc balancer.ClientConn, bOpts balancer.BuildOptions) balancer.Balancer {
+	b := &clusterImplBalancer{
+		ClientConn: cc,
+		bOpts:      bOpts,
+		closed:     grpcsync.NewEvent(),
+		done:       grpcsync.NewEvent(),
+
+		pickerUpdateCh: make(chan interface{}, 1),
+		scUpdateCh:     make(chan balancer.SubConn),
+		scToLocality:   make(map[balancer.SubConn]string),
+		scStates:       make(map[balancer.SubConn]balancer.SubConnState),
+	}
+	b.logger = internalgrpclog.NewPrefixLogger(logger, fmt.Sprintf("[xds-cluster-impl-lb %p] ", b))
+	go b.run()
+	return b
+}
+
+func (bb) Name() string {
+	return Name
+}
+
+func (bb) ParseConfig(c json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	return parseConfig(c)
+}
+
+// clusterImplBalancer forwards RPCs to its child policy, but also applies
+// the cluster-level functionality described by LBConfig: drops, circuit
+// breaking, outlier detection ejection, and LRS load reporting.
+type clusterImplBalancer struct {
+	balancer.ClientConn
+
+	bOpts  balancer.BuildOptions
+	logger *internalgrpclog.PrefixLogger
+	closed *grpcsync.Event
+	done   *grpcsync.Event
+
+	config         *LBConfig
+	edsServiceName string
+
+	child      balancer.Balancer
+	childState balancer.State
+
+	xdsClient xdsclient.XDSClient
+
+	mu           sync.Mutex
+	scToLocality map[balancer.SubConn]string
+	// scStates holds the most recently observed real SubConnState for
+	// every SubConn, keyed exactly like scToLocality. It is consulted by
+	// run when forwarding a state to the child policy, since a SubConn
+	// currently ejected by outlier detection must have a synthetic
+	// TRANSIENT_FAILURE forwarded instead of this real state - and its
+	// real state must still be on hand to restore once it is un-ejected.
+	scStates map[balancer.SubConn]balancer.SubConnState
+
+	// drops holds one dropper per configured drop category, keyed by
+	// category name. Token-bucket-backed droppers are reused across config
+	// updates that don't change their rate, so that accumulated tokens (and
+	// therefore the drop decision) survive picker rebuilds triggered by
+	// SubConn state changes rather than being reset on every rebuild.
+	drops map[string]*dropper
+
+	loadWrapper *loadStoreWrapper
+	ejections   *ejectionTracker
+
+	pickerUpdateCh chan interface{}
+	// scUpdateCh carries every SubConn whose effective state the child
+	// policy needs to be told about - both those forwarded directly from a
+	// real UpdateSubConnState call and those synthesized when outlier
+	// detection ejects or un-ejects a SubConn from its own timer goroutine
+	// - so that run is the only goroutine ever calling into the child's
+	// UpdateSubConnState, and every change to it is applied in one serial
+	// stream regardless of where it originated.
+	scUpdateCh chan balancer.SubConn
+}
+
+// ccWrapper intercepts NewSubConn/RemoveSubConn so the parent balancer can
+// remember, for each created SubConn, the locality of the address it was
+// created with. This is how load reports are attributed to a locality by
+// the picker, without having to change the balancer.SubConn type that child
+// policies see.
+type ccWrapper struct {
+	balancer.ClientConn
+	parent *clusterImplBalancer
+}
+
+func (c *ccWrapper) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc, err := c.ClientConn.NewSubConn(addrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) > 0 {
+		lID := xdsinternal.GetLocalityID(addrs[0])
+		l, _ := lID.ToString()
+		c.parent.mu.Lock()
+		c.parent.scToLocality[sc] = l
+		c.parent.mu.Unlock()
+	}
+	return sc, nil
+}
+
+func (c *ccWrapper) RemoveSubConn(sc balancer.SubConn) {
+	c.parent.mu.Lock()
+	delete(c.parent.scToLocality, sc)
+	delete(c.parent.scStates, sc)
+	c.parent.mu.Unlock()
+	if c.parent.ejections != nil {
+		c.parent.ejections.removeSubConn(sc)
+	}
+	c.ClientConn.RemoveSubConn(sc)
+}
+
+func (c *ccWrapper) UpdateState(s balancer.State) {
+	c.parent.mu.Lock()
+	c.parent.childState = s
+	c.parent.mu.Unlock()
+	select {
+	case <-c.parent.pickerUpdateCh:
+	default:
+	}
+	c.parent.pickerUpdateCh <- s
+}
+
+func (b *clusterImplBalancer) localityForSubConn(sc balancer.SubConn) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.scToLocality[sc]
+}
+
+func (b *clusterImplBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if b.closed.HasFired() {
+		b.logger.Warningf("UpdateClientConnState called after balancer is closed")
+		return nil
+	}
+	newConfig, ok := s.BalancerConfig.(*LBConfig)
+	if !ok {
+		return fmt.Errorf("unexpected balancer config with type: %T", s.BalancerConfig)
+	}
+
+	xdsC := xdsclient.FromResolverState(s.ResolverState)
+	if xdsC == nil {
+		return balancer.ErrBadResolverState
+	}
+	b.xdsClient = xdsC
+
+	if b.config == nil || b.config.Cluster != newConfig.Cluster || b.config.EDSServiceName != newConfig.EDSServiceName {
+		if b.loadWrapper != nil {
+			b.loadWrapper.stop()
+		}
+		b.loadWrapper = newLoadStoreWrapper(b.xdsClient, newConfig.Cluster, newConfig.EDSServiceName)
+	}
+	b.loadWrapper.updateServers(lrsServersFromConfig(newConfig))
+
+	if b.ejections == nil {
+		b.ejections = newEjectionTracker(b.onEjectionChange)
+	}
+	b.ejections.updateConfig(newConfig.OutlierDetection)
+
+	b.mu.Lock()
+	b.drops = refreshDrops(b.drops, newConfig.DropCategories)
+	b.mu.Unlock()
+
+	b.config = newConfig
+	b.edsServiceName = newConfig.EDSServiceName
+
+	if b.child == nil {
+		childBuilder, _, ok := xdsbalancer.Get(newConfig.ChildPolicy.Name)
+		if !ok {
+			childBuilder = balancer.Get(newConfig.ChildPolicy.Name)
+		}
+		if childBuilder == nil {
+			return fmt.Errorf("child policy %q not registered", newConfig.ChildPolicy.Name)
+		}
+		b.child = childBuilder.Build(&ccWrapper{ClientConn: b.ClientConn, parent: b}, b.bOpts)
+	}
+
+	return b.child.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState:  s.ResolverState,
+		BalancerConfig: newConfig.ChildPolicy.Config,
+	})
+}
+
+func (b *clusterImplBalancer) ResolverError(err error) {
+	if b.child != nil {
+		b.child.ResolverError(err)
+	}
+}
+
+func (b *clusterImplBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	if b.ejections != nil {
+		b.ejections.updateSubConnState(sc, s)
+	}
+	b.mu.Lock()
+	b.scStates[sc] = s
+	b.mu.Unlock()
+	b.enqueueSubConnUpdate(sc)
+}
+
+// onEjectionChange is ejections' onChange callback: it runs on the ejection
+// tracker's own timer goroutine whenever outlier detection ejects or
+// un-ejects sc, off the grpc-serialized balancer call path, so it only
+// enqueues sc for run to act on rather than touching the child directly.
+func (b *clusterImplBalancer) onEjectionChange(sc balancer.SubConn) {
+	b.enqueueSubConnUpdate(sc)
+}
+
+func (b *clusterImplBalancer) enqueueSubConnUpdate(sc balancer.SubConn) {
+	select {
+	case b.scUpdateCh <- sc:
+	case <-b.closed.Done():
+	}
+}
+
+func (b *clusterImplBalancer) Close() {
+	b.closed.Fire()
+	if b.child != nil {
+		b.child.Close()
+		b.child = nil
+	}
+	if b.loadWrapper != nil {
+		b.loadWrapper.stop()
+	}
+	if b.ejections != nil {
+		b.ejections.close()
+	}
+	<-b.done.Done()
+}
+
+func (b *clusterImplBalancer) ExitIdle() {
+	if ei, ok := b.child.(balancer.ExitIdler); ok {
+		ei.ExitIdle()
+		return
+	}
+}
+
+// run handles picker updates from the child policy, rebuilding and pushing a
+// new picker each time state changes, and forwards every SubConn state
+// change - whether a real one from grpc or one synthesized by outlier
+// detection ejecting or un-ejecting a SubConn - to the child policy, until
+// the balancer is closed. It is the only goroutine that ever calls into the
+// child, so these two kinds of updates are always applied to it in a
+// single serial stream.
+func (b *clusterImplBalancer) run() {
+	defer b.done.Fire()
+	for {
+		select {
+		case u := <-b.pickerUpdateCh:
+			if b.closed.HasFired() {
+				return
+			}
+			s := u.(balancer.State)
+			b.mu.Lock()
+			drops := b.drops
+			b.mu.Unlock()
+			b.ClientConn.UpdateState(balancer.State{
+				ConnectivityState: s.ConnectivityState,
+				Picker:            newPicker(s, b.config, drops, b.loadWrapper, b.ejections, b),
+			})
+		case sc := <-b.scUpdateCh:
+			if b.closed.HasFired() || b.child == nil {
+				continue
+			}
+			b.mu.Lock()
+			state := b.scStates[sc]
+			b.mu.Unlock()
+			if b.ejections != nil && b.ejections.isEjected(sc) {
+				state = ejectedSubConnState()
+			}
+			b.child.UpdateSubConnState(sc, state)
+		case <-b.closed.Done():
+			return
+		}
+	}
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/qiaohao9/grpc/internal/grpctest"
 	internalserviceconfig "github.com/qiaohao9/grpc/internal/serviceconfig"
 	"github.com/qiaohao9/grpc/resolver"
+	xdsbalancer "github.com/qiaohao9/grpc/xds/balancer"
 	xdsinternal "github.com/qiaohao9/grpc/xds/internal"
 	"github.com/qiaohao9/grpc/xds/internal/testutils"
 	"github.com/qiaohao9/grpc/xds/internal/testutils/fakeclient"
@@ -241,6 +242,100 @@ func (s) TestDropByCategory(t *testing.T) {
 	}
 }
 
+// TestDropByRatePerSecond verifies that a drop category configured with
+// MaxRequestsPerSecond drops using a token bucket instead of a probabilistic
+// trial, and that the drops are still reported under the category name.
+func (s) TestDropByRatePerSecond(t *testing.T) {
+	defer xdsclient.ClearCounterForTesting(testClusterName, testServiceName)
+	xdsC := fakeclient.NewClient()
+	defer xdsC.Close()
+
+	builder := balancer.Get(Name)
+	cc := testutils.NewTestClientConn(t)
+	b := builder.Build(cc, balancer.BuildOptions{})
+	defer b.Close()
+
+	const (
+		dropReason = "test-dropping-category-rate"
+		dropRate   = 1
+	)
+	if err := b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: xdsclient.SetClient(resolver.State{Addresses: testBackendAddrs}, xdsC),
+		BalancerConfig: &LBConfig{
+			Cluster:                 testClusterName,
+			EDSServiceName:          testServiceName,
+			LoadReportingServerName: newString(testLRSServerName),
+			DropCategories: []DropConfig{{
+				Category:             dropReason,
+				MaxRequestsPerSecond: newUint32(dropRate),
+			}},
+			ChildPolicy: &internalserviceconfig.BalancerConfig{
+				Name: roundrobin.Name,
+			},
+		},
+	}); err != nil {
+		t.Fatalf("unexpected error from UpdateClientConnState: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+	if _, err := xdsC.WaitForReportLoad(ctx); err != nil {
+		t.Fatalf("xdsClient.ReportLoad failed with error: %v", err)
+	}
+
+	sc1 := <-cc.NewSubConnCh
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	p1 := <-cc.NewPickerCh
+
+	const rpcCount = 5
+	const dropCount = rpcCount - dropRate
+	for i := 0; i < rpcCount; i++ {
+		gotSCSt, err := p1.Pick(balancer.PickInfo{})
+		if i < dropRate {
+			if err != nil || !cmp.Equal(gotSCSt.SubConn, sc1, cmp.AllowUnexported(testutils.TestSubConn{})) {
+				t.Fatalf("picker.Pick, got %v, %v, want SubConn=%v", gotSCSt, err, sc1)
+			}
+			if gotSCSt.Done != nil {
+				gotSCSt.Done(balancer.DoneInfo{})
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "dropped") {
+			t.Fatalf("pick.Pick, got %v, %v, want error RPC dropped", gotSCSt, err)
+		}
+	}
+
+	loadStore := xdsC.LoadStore()
+	if loadStore == nil {
+		t.Fatal("loadStore is nil in xdsClient")
+	}
+	wantStatsData := []*load.Data{{
+		Cluster:    testClusterName,
+		Service:    testServiceName,
+		TotalDrops: dropCount,
+		Drops:      map[string]uint64{dropReason: dropCount},
+		LocalityStats: map[string]load.LocalityData{
+			assertString(xdsinternal.LocalityID{}.ToString): {RequestStats: load.RequestData{Succeeded: dropRate}},
+		},
+	}}
+	gotStatsData := loadStore.Stats([]string{testClusterName})
+	if diff := cmp.Diff(gotStatsData, wantStatsData, cmpOpts); diff != "" {
+		t.Fatalf("got unexpected reports, diff (-got, +want): %v", diff)
+	}
+
+	// A picker rebuild (e.g. triggered by a SubConn state change) must not
+	// reset the token bucket's accumulated state.
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	select {
+	case p2 := <-cc.NewPickerCh:
+		if _, err := p2.Pick(balancer.PickInfo{}); err == nil || !strings.Contains(err.Error(), "dropped") {
+			t.Fatalf("picker.Pick after rebuild, got err=%v, want RPC still dropped", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for picker rebuild")
+	}
+}
+
 // TestDropCircuitBreaking verifies that the balancer correctly drops the picks
 // due to circuit breaking, and that the drops are reported.
 func (s) TestDropCircuitBreaking(t *testing.T) {
@@ -419,6 +514,74 @@ func (s) TestPickerUpdateAfterClose(t *testing.T) {
 	}
 }
 
+// fakeChildPolicyBuilder is a minimal balancer.Builder/balancer.Balancer
+// used to verify that cluster_impl resolves child policies through the
+// xds/balancer registry, without registering anything in the main balancer
+// registry that TestChildPolicyFromXDSBalancerRegistry could accidentally
+// pick up instead.
+type fakeChildPolicyBuilder struct{}
+
+func (*fakeChildPolicyBuilder) Build(cc balancer.ClientConn, _ balancer.BuildOptions) balancer.Balancer {
+	return &fakeChildPolicy{cc: cc}
+}
+
+type fakeChildPolicy struct {
+	cc balancer.ClientConn
+}
+
+func (p *fakeChildPolicy) UpdateClientConnState(ccs balancer.ClientConnState) error {
+	_, err := p.cc.NewSubConn(ccs.ResolverState.Addresses, balancer.NewSubConnOptions{})
+	return err
+}
+
+func (*fakeChildPolicy) ResolverError(error) {}
+
+func (*fakeChildPolicy) UpdateSubConnState(balancer.SubConn, balancer.SubConnState) {}
+
+func (*fakeChildPolicy) Close() {}
+
+// TestChildPolicyFromXDSBalancerRegistry verifies that a child policy
+// registered only with the xds/balancer registry (and not with the main
+// balancer registry), as a custom xDS-managed policy would be, is still
+// picked up by the cluster_impl balancer.
+func (s) TestChildPolicyFromXDSBalancerRegistry(t *testing.T) {
+	defer xdsclient.ClearCounterForTesting(testClusterName, testServiceName)
+	xdsC := fakeclient.NewClient()
+	defer xdsC.Close()
+
+	const childPolicyName = "test-xds-registry-only-child-policy"
+	xdsbalancer.Register(childPolicyName, &fakeChildPolicyBuilder{}, nil)
+	defer xdsbalancer.Unregister(childPolicyName)
+
+	if builder := balancer.Get(childPolicyName); builder != nil {
+		t.Fatalf("child policy %q unexpectedly found in the main balancer registry", childPolicyName)
+	}
+
+	builder := balancer.Get(Name)
+	cc := testutils.NewTestClientConn(t)
+	b := builder.Build(cc, balancer.BuildOptions{})
+	defer b.Close()
+
+	if err := b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: xdsclient.SetClient(resolver.State{Addresses: testBackendAddrs}, xdsC),
+		BalancerConfig: &LBConfig{
+			Cluster:        testClusterName,
+			EDSServiceName: testServiceName,
+			ChildPolicy: &internalserviceconfig.BalancerConfig{
+				Name: childPolicyName,
+			},
+		},
+	}); err != nil {
+		t.Fatalf("unexpected error from UpdateClientConnState: %v", err)
+	}
+
+	select {
+	case <-cc.NewSubConnCh:
+	case <-time.After(defaultTestTimeout):
+		t.Fatal("timed out waiting for the xds-registry-only child policy to be built and used")
+	}
+}
+
 // TestClusterNameInAddressAttributes covers the case that cluster name is
 // attached to the subconn address attributes.
 func (s) TestClusterNameInAddressAttributes(t *testing.T) {
@@ -792,6 +955,89 @@ func (s) TestUpdateLRSServer(t *testing.T) {
 	}
 }
 
+// TestLRSServerFanout covers the cases
+// - the init config specifies two LRS servers via LoadReportingServers
+// - config drops one of the two servers, which should cancel only that one
+// - config adds a third server on top of the remaining one
+func (s) TestLRSServerFanout(t *testing.T) {
+	const (
+		lrsServerA = "test-lrs-server-a"
+		lrsServerB = "test-lrs-server-b"
+		lrsServerC = "test-lrs-server-c"
+	)
+
+	xdsC := fakeclient.NewClient()
+	defer xdsC.Close()
+
+	builder := balancer.Get(Name)
+	cc := testutils.NewTestClientConn(t)
+	b := builder.Build(cc, balancer.BuildOptions{})
+	defer b.Close()
+
+	if err := b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: xdsclient.SetClient(resolver.State{Addresses: testBackendAddrs}, xdsC),
+		BalancerConfig: &LBConfig{
+			Cluster:              testClusterName,
+			EDSServiceName:       testServiceName,
+			LoadReportingServers: []string{lrsServerA, lrsServerB},
+			ChildPolicy: &internalserviceconfig.BalancerConfig{
+				Name: roundrobin.Name,
+			},
+		},
+	}); err != nil {
+		t.Fatalf("unexpected error from UpdateClientConnState: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+
+	gotServers := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		got, err := xdsC.WaitForReportLoad(ctx)
+		if err != nil {
+			t.Fatalf("xdsClient.ReportLoad failed with error: %v", err)
+		}
+		gotServers[got.Server] = true
+	}
+	if !gotServers[lrsServerA] || !gotServers[lrsServerB] {
+		t.Fatalf("xdsClient.ReportLoad called with %v, want calls for both %q and %q", gotServers, lrsServerA, lrsServerB)
+	}
+
+	// Drop server B, add server C. Only B's stream should be canceled; A's
+	// accumulated counters are untouched since it stays configured.
+	if err := b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: xdsclient.SetClient(resolver.State{Addresses: testBackendAddrs}, xdsC),
+		BalancerConfig: &LBConfig{
+			Cluster:              testClusterName,
+			EDSServiceName:       testServiceName,
+			LoadReportingServers: []string{lrsServerA, lrsServerC},
+			ChildPolicy: &internalserviceconfig.BalancerConfig{
+				Name: roundrobin.Name,
+			},
+		},
+	}); err != nil {
+		t.Fatalf("unexpected error from UpdateClientConnState: %v", err)
+	}
+	if err := xdsC.WaitForCancelReportLoad(ctx); err != nil {
+		t.Fatalf("unexpected error waiting for load report to be canceled: %v", err)
+	}
+	got, err := xdsC.WaitForReportLoad(ctx)
+	if err != nil {
+		t.Fatalf("xdsClient.ReportLoad failed with error: %v", err)
+	}
+	if got.Server != lrsServerC {
+		t.Fatalf("xdsClient.ReportLoad called with {%q}: want {%q}", got.Server, lrsServerC)
+	}
+}
+
+func newString(s string) *string {
+	return &s
+}
+
+func newUint32(i uint32) *uint32 {
+	return &i
+}
+
 func assertString(f func() (string, error)) string {
 	s, err := f()
 	if err != nil {
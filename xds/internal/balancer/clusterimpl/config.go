@@ -0,0 +1,112 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package clusterimpl
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/qiaohao9/grpc/serviceconfig"
+
+	internalserviceconfig "github.com/qiaohao9/grpc/internal/serviceconfig"
+)
+
+// DropConfig contains the config to drop requests for a category.
+type DropConfig struct {
+	Category           string
+	RequestsPerMillion  uint32
+	// MaxRequestsPerSecond, if set, switches this category to a deterministic
+	// per-second token bucket cap instead of the probabilistic
+	// RequestsPerMillion trial. The bucket is refilled at this rate and its
+	// burst size is also bounded by this rate.
+	MaxRequestsPerSecond *uint32
+}
+
+// OutlierDetectionConfig contains the config for the per-endpoint ejection
+// subsystem, roughly mirroring the Envoy outlier detection extension.
+type OutlierDetectionConfig struct {
+	// Interval is the time between ejection sweeps.
+	Interval time.Duration
+	// BaseEjectionTime is the base time an endpoint remains ejected for; the
+	// actual ejection time is BaseEjectionTime multiplied by the number of
+	// times the endpoint has previously been ejected, capped at
+	// MaxEjectionTime.
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime caps the ejection duration computed from
+	// BaseEjectionTime.
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent is the maximum percentage of endpoints in the
+	// cluster that may be ejected at any one time.
+	MaxEjectionPercent uint32
+
+	// SuccessRateStdevFactor, SuccessRateMinimumHosts, and
+	// SuccessRateRequestVolume configure the success-rate-based detector: an
+	// endpoint is a candidate for ejection when its success rate is more
+	// than (stdev * SuccessRateStdevFactor / 1000) below the mean, but only
+	// once the cluster has at least SuccessRateMinimumHosts endpoints with at
+	// least SuccessRateRequestVolume requests in the interval.
+	SuccessRateStdevFactor   uint32
+	SuccessRateMinimumHosts  uint32
+	SuccessRateRequestVolume uint32
+	EnforcingSuccessRate     uint32
+
+	// ConsecutiveGatewayFailure is the number of consecutive gateway
+	// failures (its own detector, independent of success rate) required
+	// before an endpoint becomes a candidate for ejection.
+	ConsecutiveGatewayFailure   uint32
+	EnforcingConsecutiveGatewayFailure uint32
+}
+
+// LBConfig is the balancer config for cluster_impl balancer.
+type LBConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	Cluster        string `json:"cluster,omitempty"`
+	EDSServiceName string `json:"edsServiceName,omitempty"`
+	// LoadReportingServerName is the name of the LRS server to report load
+	// to, kept for backward compatibility with single-destination configs.
+	// A nil value means load reporting is disabled, while an empty string
+	// means the default LRS server (the same server this cluster's EDS data
+	// came from) should be used.
+	LoadReportingServerName *string `json:"lrsLoadReportingServerName,omitempty"`
+	// LoadReportingServers is the list of LRS server names to report load
+	// to. When non-empty, it takes precedence over
+	// LoadReportingServerName, and load is fanned out to every server
+	// named here.
+	LoadReportingServers []string `json:"lrsLoadReportingServers,omitempty"`
+
+	MaxConcurrentRequests *uint32      `json:"maxConcurrentRequests,omitempty"`
+	DropCategories        []DropConfig `json:"dropCategories,omitempty"`
+	// OutlierDetection configures per-endpoint ejection. A nil value
+	// disables outlier detection entirely.
+	OutlierDetection *OutlierDetectionConfig `json:"outlierDetection,omitempty"`
+
+	ChildPolicy *internalserviceconfig.BalancerConfig `json:"childPolicy,omitempty"`
+}
+
+// million is the denominator used when evaluating DropConfig.RequestsPerMillion.
+const million = 1000000
+
+func parseConfig(c json.RawMessage) (*LBConfig, error) {
+	var cfg LBConfig
+	if err := json.Unmarshal(c, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
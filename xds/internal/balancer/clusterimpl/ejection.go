@@ -0,0 +1,319 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package clusterimpl
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/connectivity"
+	"github.com/qiaohao9/grpc/status"
+)
+
+// errSubConnEjected is the ConnectionError reported, via the synthetic
+// SubConnState built by ejectedSubConnState, to the child policy for a
+// SubConn that outlier detection has ejected.
+var errSubConnEjected = errors.New("xds: subconn ejected by outlier detection")
+
+// ejectedSubConnState is the synthetic SubConnState forwarded to the child
+// policy, in place of the SubConn's real state, for as long as it is
+// ejected - so that the child stops routing to it (e.g. round_robin drops
+// it from rotation) without the underlying connection being torn down.
+func ejectedSubConnState() balancer.SubConnState {
+	return balancer.SubConnState{ConnectivityState: connectivity.TransientFailure, ConnectionError: errSubConnEjected}
+}
+
+// endpointCounts is the rolling success/failure tally for a single SubConn
+// over the current outlier detection interval.
+type endpointCounts struct {
+	success, failure uint32
+	consecutiveGatewayFailure uint32
+}
+
+// ejectionTracker implements the per-endpoint outlier detection ejection
+// subsystem described in the Envoy outlier detection extension: it
+// piggybacks on the Done callback wiring already used for load reporting to
+// track per-SubConn success/failure counts, and periodically (every
+// Config.Interval) ejects SubConns whose success rate or consecutive
+// gateway failure count crosses the configured thresholds. Ejected SubConns
+// are reported to the child policy as a synthetic TRANSIENT_FAILURE by the
+// picker, without the underlying connection being torn down, and are
+// un-ejected after Config.BaseEjectionTime multiplied by the number of
+// times they've previously been ejected (capped at Config.MaxEjectionTime).
+type ejectionTracker struct {
+	mu        sync.Mutex
+	config    *OutlierDetectionConfig
+	counts    map[balancer.SubConn]*endpointCounts
+	ejected   map[balancer.SubConn]*ejectionState
+	totalEndpoints int
+
+	stopTimer func()
+
+	// onChange is invoked, with e.mu not held, once for every SubConn whose
+	// ejected status changed during the most recent sweep. The balancer
+	// uses it to recompute that SubConn's effective state and forward it to
+	// the child policy, the same way it does for a real SubConnState
+	// update, so an ejection is never visible to the child only as a
+	// delayed side effect of the next Pick.
+	onChange func(balancer.SubConn)
+}
+
+type ejectionState struct {
+	ejectionTime time.Time
+	ejectionDuration time.Duration
+	timesEjected int
+}
+
+func newEjectionTracker(onChange func(balancer.SubConn)) *ejectionTracker {
+	return &ejectionTracker{
+		counts:   make(map[balancer.SubConn]*endpointCounts),
+		ejected:  make(map[balancer.SubConn]*ejectionState),
+		onChange: onChange,
+	}
+}
+
+// updateConfig installs a new outlier detection config, (re)starting or
+// stopping the periodic sweep as necessary.
+func (e *ejectionTracker) updateConfig(cfg *OutlierDetectionConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.config = cfg
+	if e.stopTimer != nil {
+		e.stopTimer()
+		e.stopTimer = nil
+	}
+	if cfg == nil || cfg.Interval <= 0 {
+		return
+	}
+	stopCh := make(chan struct{})
+	go e.run(cfg.Interval, stopCh)
+	var stopOnce sync.Once
+	e.stopTimer = func() { stopOnce.Do(func() { close(stopCh) }) }
+}
+
+func (e *ejectionTracker) run(interval time.Duration, stopCh chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.sweep()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// sweep evaluates every tracked endpoint against the configured detectors,
+// ejects newly-failing endpoints (subject to MaxEjectionPercent), un-ejects
+// endpoints whose ejection duration has elapsed, and resets the interval
+// counters.
+func (e *ejectionTracker) sweep() {
+	e.mu.Lock()
+	cfg := e.config
+	if cfg == nil {
+		e.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	var changed []balancer.SubConn
+	for sc, st := range e.ejected {
+		if now.Sub(st.ejectionTime) >= st.ejectionDuration {
+			delete(e.ejected, sc)
+			changed = append(changed, sc)
+		}
+	}
+
+	maxEjected := (cfg.MaxEjectionPercent * uint32(len(e.counts))) / 100
+	if maxEjected == 0 && cfg.MaxEjectionPercent > 0 {
+		maxEjected = 1
+	}
+
+	if cfg.ConsecutiveGatewayFailure > 0 {
+		for sc, c := range e.counts {
+			if _, already := e.ejected[sc]; already {
+				continue
+			}
+			if uint32(len(e.ejected)) >= maxEjected {
+				break
+			}
+			if c.consecutiveGatewayFailure >= cfg.ConsecutiveGatewayFailure && shouldEnforce(cfg.EnforcingConsecutiveGatewayFailure) {
+				e.eject(sc, now)
+				changed = append(changed, sc)
+			}
+		}
+	}
+
+	if cfg.SuccessRateRequestVolume > 0 && uint32(len(e.counts)) >= cfg.SuccessRateMinimumHosts {
+		mean, stdev := successRateStats(e.counts, cfg.SuccessRateRequestVolume)
+		threshold := mean - stdev*float64(cfg.SuccessRateStdevFactor)/1000
+		for sc, c := range e.counts {
+			if _, already := e.ejected[sc]; already {
+				continue
+			}
+			if uint32(len(e.ejected)) >= maxEjected {
+				break
+			}
+			total := c.success + c.failure
+			if total < cfg.SuccessRateRequestVolume {
+				continue
+			}
+			rate := float64(c.success) / float64(total)
+			if rate < threshold && shouldEnforce(cfg.EnforcingSuccessRate) {
+				e.eject(sc, now)
+				changed = append(changed, sc)
+			}
+		}
+	}
+
+	for _, c := range e.counts {
+		c.success, c.failure, c.consecutiveGatewayFailure = 0, 0, 0
+	}
+	e.mu.Unlock()
+
+	if e.onChange != nil {
+		for _, sc := range changed {
+			e.onChange(sc)
+		}
+	}
+}
+
+func successRateStats(counts map[balancer.SubConn]*endpointCounts, minVolume uint32) (mean, stdev float64) {
+	var rates []float64
+	for _, c := range counts {
+		total := c.success + c.failure
+		if total < minVolume {
+			continue
+		}
+		rates = append(rates, float64(c.success)/float64(total))
+	}
+	if len(rates) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, r := range rates {
+		sum += r
+	}
+	mean = sum / float64(len(rates))
+	var variance float64
+	for _, r := range rates {
+		variance += (r - mean) * (r - mean)
+	}
+	variance /= float64(len(rates))
+	return mean, math.Sqrt(variance)
+}
+
+// shouldEnforce rolls a percent-chance die to decide whether a candidate
+// ejection should actually be enforced, per the Enforcing* knobs.
+func shouldEnforce(percent uint32) bool {
+	if percent >= 100 {
+		return true
+	}
+	if percent == 0 {
+		return false
+	}
+	return uint32(randIntn(100)) < percent
+}
+
+// randIntn is a package-level indirection so tests can make ejection
+// decisions deterministic.
+var randIntn = func(n int) int {
+	return int(time.Now().UnixNano() % int64(n))
+}
+
+func (e *ejectionTracker) eject(sc balancer.SubConn, now time.Time) {
+	st, ok := e.ejected[sc]
+	if !ok {
+		st = &ejectionState{}
+		e.ejected[sc] = st
+	}
+	st.timesEjected++
+	st.ejectionTime = now
+	dur := e.config.BaseEjectionTime * time.Duration(st.timesEjected)
+	if e.config.MaxEjectionTime > 0 && dur > e.config.MaxEjectionTime {
+		dur = e.config.MaxEjectionTime
+	}
+	st.ejectionDuration = dur
+}
+
+// updateSubConnState starts or stops tracking a SubConn as it is created or
+// removed by the child policy.
+func (e *ejectionTracker) updateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.counts[sc]; !ok {
+		e.counts[sc] = &endpointCounts{}
+	}
+}
+
+func (e *ejectionTracker) callStarted(sc balancer.SubConn) {}
+
+// removeSubConn stops tracking sc, dropping its rolling counters and
+// ejection state so that SubConns removed by the child policy don't leak
+// for the remaining lifetime of the balancer.
+func (e *ejectionTracker) removeSubConn(sc balancer.SubConn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.counts, sc)
+	delete(e.ejected, sc)
+}
+
+// callFinished records the outcome of a completed RPC against sc's rolling
+// counters. Any error is treated as a failure for success-rate purposes; a
+// codes.Unavailable-class error additionally counts toward
+// ConsecutiveGatewayFailure.
+func (e *ejectionTracker) callFinished(sc balancer.SubConn, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c, ok := e.counts[sc]
+	if !ok {
+		c = &endpointCounts{}
+		e.counts[sc] = c
+	}
+	if err == nil {
+		c.success++
+		c.consecutiveGatewayFailure = 0
+		return
+	}
+	c.failure++
+	if status.Code(err) == codes.Unavailable {
+		c.consecutiveGatewayFailure++
+	}
+}
+
+// isEjected reports whether sc is currently ejected.
+func (e *ejectionTracker) isEjected(sc balancer.SubConn) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.ejected[sc]
+	return ok
+}
+
+func (e *ejectionTracker) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopTimer != nil {
+		e.stopTimer()
+		e.stopTimer = nil
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package clusterimpl
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/resolver"
+	"github.com/qiaohao9/grpc/status"
+)
+
+// fakeSubConn is the minimal balancer.SubConn implementation needed to use
+// one as a distinct map key in ejectionTracker's tests.
+type fakeSubConn struct{}
+
+func (*fakeSubConn) UpdateAddresses([]resolver.Address) {}
+func (*fakeSubConn) Connect()                           {}
+
+// changeRecorder collects the SubConns reported via ejectionTracker's
+// onChange callback, for assertions.
+type changeRecorder struct {
+	mu      sync.Mutex
+	changed []balancer.SubConn
+}
+
+func (r *changeRecorder) onChange(sc balancer.SubConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changed = append(r.changed, sc)
+}
+
+func (r *changeRecorder) contains(sc balancer.SubConn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.changed {
+		if s == sc {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestEjectionTracker returns an ejectionTracker configured with cfg but
+// without starting the background sweep goroutine updateConfig would
+// normally start, so tests can invoke sweep directly and deterministically.
+func newTestEjectionTracker(cfg *OutlierDetectionConfig, r *changeRecorder) *ejectionTracker {
+	e := newEjectionTracker(r.onChange)
+	e.config = cfg
+	return e
+}
+
+// (s) TestEjectionTracker_ConsecutiveGatewayFailureEjectsAndUnejects verifies
+// that a SubConn accruing enough consecutive codes.Unavailable failures is
+// ejected on the next sweep, and un-ejected once its ejection duration has
+// elapsed.
+func (s) TestEjectionTracker_ConsecutiveGatewayFailureEjectsAndUnejects(t *testing.T) {
+	r := &changeRecorder{}
+	e := newTestEjectionTracker(&OutlierDetectionConfig{
+		BaseEjectionTime:                   30 * time.Second,
+		MaxEjectionTime:                    300 * time.Second,
+		MaxEjectionPercent:                 100,
+		ConsecutiveGatewayFailure:          3,
+		EnforcingConsecutiveGatewayFailure: 100,
+	}, r)
+
+	sc := &fakeSubConn{}
+	e.updateSubConnState(sc, balancer.SubConnState{})
+	for i := 0; i < 3; i++ {
+		e.callFinished(sc, status.Error(codes.Unavailable, "backend down"))
+	}
+
+	e.sweep()
+	if !e.isEjected(sc) {
+		t.Fatalf("sc not ejected after %d consecutive Unavailable failures, want ejected", 3)
+	}
+	if !r.contains(sc) {
+		t.Errorf("onChange was not invoked for the newly ejected SubConn")
+	}
+
+	// Rewind the recorded ejection time so the next sweep sees the
+	// ejection duration as elapsed, without needing to actually sleep.
+	e.mu.Lock()
+	e.ejected[sc].ejectionTime = time.Now().Add(-time.Hour)
+	e.mu.Unlock()
+
+	e.sweep()
+	if e.isEjected(sc) {
+		t.Errorf("sc still ejected after its ejection duration elapsed, want un-ejected")
+	}
+}
+
+// (s) TestEjectionTracker_NonGatewayFailureDoesNotEject verifies that
+// failures whose code is not codes.Unavailable never accrue toward
+// ConsecutiveGatewayFailure, so such a SubConn is never ejected by that
+// detector regardless of how many of them occur.
+func (s) TestEjectionTracker_NonGatewayFailureDoesNotEject(t *testing.T) {
+	r := &changeRecorder{}
+	e := newTestEjectionTracker(&OutlierDetectionConfig{
+		BaseEjectionTime:                   30 * time.Second,
+		MaxEjectionPercent:                 100,
+		ConsecutiveGatewayFailure:          3,
+		EnforcingConsecutiveGatewayFailure: 100,
+	}, r)
+
+	sc := &fakeSubConn{}
+	e.updateSubConnState(sc, balancer.SubConnState{})
+	for i := 0; i < 5; i++ {
+		e.callFinished(sc, status.Error(codes.InvalidArgument, "bad request"))
+	}
+
+	e.sweep()
+	if e.isEjected(sc) {
+		t.Errorf("sc ejected after only non-Unavailable failures, want not ejected")
+	}
+}
+
+// (s) TestEjectionTracker_RemoveSubConnCleansUpState verifies that
+// removeSubConn drops sc's entries from both counts and ejected, so that
+// SubConn churn doesn't leak tracker state for the balancer's lifetime.
+func (s) TestEjectionTracker_RemoveSubConnCleansUpState(t *testing.T) {
+	r := &changeRecorder{}
+	e := newTestEjectionTracker(&OutlierDetectionConfig{
+		BaseEjectionTime:                   30 * time.Second,
+		MaxEjectionPercent:                 100,
+		ConsecutiveGatewayFailure:          1,
+		EnforcingConsecutiveGatewayFailure: 100,
+	}, r)
+
+	sc := &fakeSubConn{}
+	e.updateSubConnState(sc, balancer.SubConnState{})
+	e.callFinished(sc, status.Error(codes.Unavailable, "backend down"))
+	e.sweep()
+	if !e.isEjected(sc) {
+		t.Fatalf("sc not ejected, want ejected before testing removal")
+	}
+
+	e.removeSubConn(sc)
+
+	e.mu.Lock()
+	_, countsOK := e.counts[sc]
+	_, ejectedOK := e.ejected[sc]
+	e.mu.Unlock()
+	if countsOK {
+		t.Errorf("counts still has an entry for sc after removeSubConn, want none")
+	}
+	if ejectedOK {
+		t.Errorf("ejected still has an entry for sc after removeSubConn, want none")
+	}
+}
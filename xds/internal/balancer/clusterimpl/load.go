@@ -0,0 +1,110 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package clusterimpl
+
+import (
+	"sync"
+
+	"github.com/qiaohao9/grpc/xds/internal/xdsclient"
+)
+
+// loadStoreWrapper fans the cluster-level load events generated by the
+// picker out to every LRS destination currently configured for this
+// cluster. Each destination is backed by its own xdsclient.ReportLoad
+// stream and therefore its own load.Store, so that adding or removing a
+// destination never perturbs the counters the others have already
+// accumulated since their last report.
+type loadStoreWrapper struct {
+	xdsClient xdsclient.XDSClient
+	cluster   string
+	service   string
+
+	mu        sync.Mutex
+	reporters map[string]loadReporterWithCancel
+
+	// local is always populated, regardless of whether any LRS server is
+	// configured, so load can be exported via RegisterMetricsExporter even
+	// when LRS itself is disabled. It costs only the map bookkeeping
+	// callStarted/callFinished/callDropped already do; the periodic export
+	// itself is handled by the package-level runMetricsExport, which only
+	// runs while a sink is actually registered, while this wrapper is listed
+	// in activeWrappers (see registerWrapper/unregisterWrapper).
+	local *localCounts
+}
+
+func newLoadStoreWrapper(xdsC xdsclient.XDSClient, cluster, service string) *loadStoreWrapper {
+	w := &loadStoreWrapper{
+		xdsClient: xdsC,
+		cluster:   cluster,
+		service:   service,
+		reporters: make(map[string]loadReporterWithCancel),
+		local:     newLocalCounts(),
+	}
+	registerWrapper(w)
+	return w
+}
+
+func (w *loadStoreWrapper) stop() {
+	if w == nil {
+		return
+	}
+	unregisterWrapper(w)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for s, r := range w.reporters {
+		r.cancel()
+		delete(w.reporters, s)
+	}
+}
+
+func (w *loadStoreWrapper) CallStarted(locality string) {
+	if w == nil {
+		return
+	}
+	w.local.callStarted(locality)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, r := range w.reporters {
+		r.store.CallStarted(locality)
+	}
+}
+
+func (w *loadStoreWrapper) CallFinished(locality string, err error) {
+	if w == nil {
+		return
+	}
+	w.local.callFinished(locality, err)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, r := range w.reporters {
+		r.store.CallFinished(locality, err)
+	}
+}
+
+func (w *loadStoreWrapper) CallDropped(category string) {
+	if w == nil {
+		return
+	}
+	w.local.callDropped(category)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, r := range w.reporters {
+		r.store.CallDropped(category)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package clusterimpl
+
+// This file holds the parts of loadStoreWrapper, and the LBConfig helpers,
+// that let a single cluster report load to more than one LRS destination at
+// once: selecting the set of destinations from an LBConfig, and starting or
+// canceling the individual xdsclient.ReportLoad streams as that set changes
+// across config updates, without disturbing the streams that remain.
+
+import "github.com/qiaohao9/grpc/xds/internal/xdsclient/load"
+
+// loadReporterWithCancel pairs the PerClusterReporter for one LRS
+// destination with the func that tears down its underlying
+// xdsclient.ReportLoad stream.
+type loadReporterWithCancel struct {
+	store  load.PerClusterReporter
+	cancel func()
+}
+
+// updateServers starts reporting load to any server in servers that isn't
+// already being reported to, and stops reporting to any server that is no
+// longer present. Each destination keeps its own PerClusterReporter, so
+// removing one server's stream never perturbs the counters another
+// destination has accumulated since its last report.
+func (w *loadStoreWrapper) updateServers(servers []string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	want := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		want[s] = true
+		if _, ok := w.reporters[s]; ok {
+			continue
+		}
+		store, cancel := w.xdsClient.ReportLoad(s)
+		w.reporters[s] = loadReporterWithCancel{
+			store:  store.PerCluster(w.cluster, w.service),
+			cancel: cancel,
+		}
+	}
+	for s, r := range w.reporters {
+		if !want[s] {
+			r.cancel()
+			delete(w.reporters, s)
+		}
+	}
+}
+
+// lrsServersFromConfig returns the set of LRS server names c's load should
+// be fanned out to: LoadReportingServers if set, else the single
+// LoadReportingServerName kept for backward compatibility, else nil if
+// load reporting is disabled.
+func lrsServersFromConfig(c *LBConfig) []string {
+	if len(c.LoadReportingServers) > 0 {
+		return c.LoadReportingServers
+	}
+	if c.LoadReportingServerName != nil {
+		return []string{*c.LoadReportingServerName}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package clusterimpl
+
+import (
+	"sync"
+	"time"
+)
+
+// metricsExportInterval is the cadence on which load snapshots are handed
+// to the registered LoadMetricsSink, matching the cadence LRS itself
+// reports on. It is a var, rather than a const, so tests can shorten it.
+var metricsExportInterval = 10 * time.Second
+
+// LoadSnapshot is one cluster's load counters at a point in time.
+type LoadSnapshot struct {
+	Cluster        string
+	EDSServiceName string
+	// Localities is keyed by the locality's opaque string id (as returned by
+	// xdsinternal.LocalityID.ToString), which already embeds region, zone,
+	// and sub_zone.
+	Localities map[string]LocalityLoadSnapshot
+	// Drops is keyed by drop category, including the synthetic
+	// "circuit_breaking" and "outlier_detection" categories.
+	Drops map[string]uint64
+}
+
+// LocalityLoadSnapshot is one locality's request counters at a point in time.
+type LocalityLoadSnapshot struct {
+	Succeeded  uint64
+	Errored    uint64
+	InProgress uint64
+}
+
+// LoadMetricsSink receives periodic snapshots of every cluster_impl
+// balancer's load counters, independent of whether LRS reporting is
+// configured for that cluster. It exists so that the same counters LRS
+// sends to the xDS management server can also be mirrored into a local
+// metrics registry (e.g. Prometheus) without this package taking a direct
+// dependency on any particular metrics client library; a Prometheus (or
+// other) adapter can be implemented on top of it outside this package.
+type LoadMetricsSink interface {
+	ReportLoad(LoadSnapshot)
+}
+
+var (
+	metricsSinkMu  sync.Mutex
+	metricsSink    LoadMetricsSink
+	activeWrappers = make(map[*loadStoreWrapper]struct{})
+	stopExport     func()
+)
+
+// RegisterMetricsExporter installs sink as the destination for periodic
+// load snapshots from every cluster_impl balancer instance in this process.
+// Passing nil disables exporting. Exporting is opt-in: the export goroutine
+// only runs while a non-nil sink is registered, so this has no overhead
+// when, as by default, no one has called it.
+func RegisterMetricsExporter(sink LoadMetricsSink) {
+	metricsSinkMu.Lock()
+	defer metricsSinkMu.Unlock()
+	metricsSink = sink
+	if sink == nil {
+		if stopExport != nil {
+			stopExport()
+			stopExport = nil
+		}
+		return
+	}
+	if stopExport != nil {
+		return
+	}
+	stopCh := make(chan struct{})
+	go runMetricsExport(stopCh)
+	var stopOnce sync.Once
+	stopExport = func() { stopOnce.Do(func() { close(stopCh) }) }
+}
+
+// runMetricsExport periodically hands every currently active
+// loadStoreWrapper's local snapshot to the registered LoadMetricsSink, for
+// as long as one remains registered. It is only ever running between a
+// RegisterMetricsExporter(non-nil) call and the matching
+// RegisterMetricsExporter(nil), so idle processes that never opt in to
+// exporting never pay for this goroutine or its ticker.
+func runMetricsExport(stopCh chan struct{}) {
+	ticker := time.NewTicker(metricsExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sink := currentMetricsSink()
+			if sink == nil {
+				continue
+			}
+			for _, w := range currentWrappers() {
+				sink.ReportLoad(w.local.snapshot(w.cluster, w.service))
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func currentMetricsSink() LoadMetricsSink {
+	metricsSinkMu.Lock()
+	defer metricsSinkMu.Unlock()
+	return metricsSink
+}
+
+// registerWrapper adds w to the set of loadStoreWrappers visited by
+// runMetricsExport, for as long as it remains registered.
+func registerWrapper(w *loadStoreWrapper) {
+	metricsSinkMu.Lock()
+	defer metricsSinkMu.Unlock()
+	activeWrappers[w] = struct{}{}
+}
+
+// unregisterWrapper removes w, e.g. because its balancer was closed or
+// rebuilt, so a stopped wrapper's stale counters are never exported again.
+func unregisterWrapper(w *loadStoreWrapper) {
+	metricsSinkMu.Lock()
+	defer metricsSinkMu.Unlock()
+	delete(activeWrappers, w)
+}
+
+func currentWrappers() []*loadStoreWrapper {
+	metricsSinkMu.Lock()
+	defer metricsSinkMu.Unlock()
+	out := make([]*loadStoreWrapper, 0, len(activeWrappers))
+	for w := range activeWrappers {
+		out = append(out, w)
+	}
+	return out
+}
+
+// localCounts is the always-on, in-memory tally backing metrics export. It
+// is maintained regardless of whether any LRS destination is configured, so
+// that load is observable locally even with LRS disabled.
+type localCounts struct {
+	mu         sync.Mutex
+	localities map[string]*LocalityLoadSnapshot
+	drops      map[string]uint64
+}
+
+func newLocalCounts() *localCounts {
+	return &localCounts{
+		localities: make(map[string]*LocalityLoadSnapshot),
+		drops:      make(map[string]uint64),
+	}
+}
+
+func (c *localCounts) callStarted(locality string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.localityLocked(locality).InProgress++
+}
+
+func (c *localCounts) callFinished(locality string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.localityLocked(locality)
+	l.InProgress--
+	if err == nil {
+		l.Succeeded++
+	} else {
+		l.Errored++
+	}
+}
+
+func (c *localCounts) callDropped(category string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drops[category]++
+}
+
+func (c *localCounts) localityLocked(locality string) *LocalityLoadSnapshot {
+	l, ok := c.localities[locality]
+	if !ok {
+		l = &LocalityLoadSnapshot{}
+		c.localities[locality] = l
+	}
+	return l
+}
+
+// snapshot returns a deep copy of the current counters for cluster/service.
+func (c *localCounts) snapshot(cluster, service string) LoadSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := LoadSnapshot{
+		Cluster:        cluster,
+		EDSServiceName: service,
+		Localities:     make(map[string]LocalityLoadSnapshot, len(c.localities)),
+		Drops:          make(map[string]uint64, len(c.drops)),
+	}
+	for locality, l := range c.localities {
+		s.Localities[locality] = *l
+	}
+	for category, n := range c.drops {
+		s.Drops[category] = n
+	}
+	return s
+}
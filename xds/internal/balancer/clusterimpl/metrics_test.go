@@ -0,0 +1,130 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package clusterimpl
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetricsSink records every LoadSnapshot handed to ReportLoad, for
+// assertions.
+type fakeMetricsSink struct {
+	mu        sync.Mutex
+	snapshots []LoadSnapshot
+}
+
+func (s *fakeMetricsSink) ReportLoad(snap LoadSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots = append(s.snapshots, snap)
+}
+
+func (s *fakeMetricsSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.snapshots)
+}
+
+// (s) TestLocalCountsSnapshot verifies that localCounts tallies
+// started/finished/dropped calls into an accurate LoadSnapshot.
+func (s) TestLocalCountsSnapshot(t *testing.T) {
+	c := newLocalCounts()
+	c.callStarted("locality-1")
+	c.callStarted("locality-1")
+	c.callFinished("locality-1", nil)
+	c.callFinished("locality-1", errors.New("backend down"))
+	c.callDropped("circuit_breaking")
+
+	snap := c.snapshot("cluster-a", "service-a")
+	if snap.Cluster != "cluster-a" || snap.EDSServiceName != "service-a" {
+		t.Fatalf("snapshot cluster/service = %q/%q, want cluster-a/service-a", snap.Cluster, snap.EDSServiceName)
+	}
+	want := LocalityLoadSnapshot{Succeeded: 1, Errored: 1, InProgress: 0}
+	if got := snap.Localities["locality-1"]; got != want {
+		t.Errorf("snapshot.Localities[locality-1] = %+v, want %+v", got, want)
+	}
+	if got := snap.Drops["circuit_breaking"]; got != 1 {
+		t.Errorf("snapshot.Drops[circuit_breaking] = %d, want 1", got)
+	}
+}
+
+// (s) TestRegisterMetricsExporterGatesExportGoroutine verifies that the
+// package-level export goroutine only runs while a non-nil sink is
+// registered, and that it stops being invoked once unregistered.
+func (s) TestRegisterMetricsExporterGatesExportGoroutine(t *testing.T) {
+	defer RegisterMetricsExporter(nil)
+
+	w := newLoadStoreWrapper(nil, "cluster-b", "")
+	defer w.stop()
+	w.CallStarted("locality-1")
+	w.CallFinished("locality-1", nil)
+
+	if stopExport != nil {
+		t.Fatalf("export goroutine running before any sink was registered")
+	}
+
+	sink := &fakeMetricsSink{}
+	origInterval := metricsExportInterval
+	metricsExportInterval = time.Millisecond
+	defer func() { metricsExportInterval = origInterval }()
+
+	RegisterMetricsExporter(sink)
+	if stopExport == nil {
+		t.Fatalf("export goroutine not started after registering a sink")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for sink.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if sink.count() == 0 {
+		t.Fatalf("sink never received a LoadSnapshot for an active wrapper")
+	}
+
+	RegisterMetricsExporter(nil)
+	if stopExport != nil {
+		t.Fatalf("export goroutine still running after unregistering the sink")
+	}
+}
+
+// (s) TestLoadStoreWrapperStopUnregisters verifies that stop removes w from
+// the set of wrappers the exporter visits, so a closed balancer's stale
+// counters are never reported again.
+func (s) TestLoadStoreWrapperStopUnregisters(t *testing.T) {
+	w := newLoadStoreWrapper(nil, "cluster-c", "")
+	found := false
+	for _, active := range currentWrappers() {
+		if active == w {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("newLoadStoreWrapper did not register itself with the exporter")
+	}
+
+	w.stop()
+	for _, active := range currentWrappers() {
+		if active == w {
+			t.Fatalf("stop did not unregister w from the exporter")
+		}
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package clusterimpl
+
+import (
+	"sync"
+	"time"
+
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/internal/wrr"
+	"github.com/qiaohao9/grpc/status"
+	"github.com/qiaohao9/grpc/xds/internal/xdsclient"
+)
+
+// dropper drops a category of requests, either probabilistically (via
+// RequestsPerMillion) or deterministically via a token bucket
+// (MaxRequestsPerSecond).
+type dropper struct {
+	category string
+	config   DropConfig
+
+	w      wrr.WRR // nil when this category uses a token bucket instead.
+	bucket *tokenBucket
+}
+
+func newDropper(c DropConfig) *dropper {
+	d := &dropper{category: c.Category, config: c}
+	if c.MaxRequestsPerSecond != nil {
+		d.bucket = newTokenBucket(*c.MaxRequestsPerSecond)
+		return d
+	}
+	w := NewRandomWRR()
+	w.Add(true, int64(c.RequestsPerMillion))
+	w.Add(false, int64(million-c.RequestsPerMillion))
+	d.w = w
+	return d
+}
+
+// drop reports whether the current request should be dropped for this
+// category.
+func (d *dropper) drop() bool {
+	if d.bucket != nil {
+		return !d.bucket.take()
+	}
+	return d.w.Next().(bool)
+}
+
+// tokenBucket is a simple refilling token bucket, used to implement a
+// deterministic per-second request cap for a drop category. Its state lives
+// in the dropper, which is kept alive across picker rebuilds (it is only
+// recreated when the corresponding DropConfig entry itself changes), so
+// accumulated tokens are not reset every time the child policy produces a
+// new picker.
+type tokenBucket struct {
+	ratePerSecond float64
+
+	mu       sync.Mutex
+	tokens   float64
+	lastFill time.Time
+}
+
+func newTokenBucket(ratePerSecond uint32) *tokenBucket {
+	return &tokenBucket{
+		ratePerSecond: float64(ratePerSecond),
+		tokens:        float64(ratePerSecond),
+		lastFill:      time.Now(),
+	}
+}
+
+// take consumes one token if available, returning whether it succeeded.
+func (tb *tokenBucket) take() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(tb.lastFill).Seconds()
+	tb.lastFill = now
+	tb.tokens += elapsed * tb.ratePerSecond
+	if tb.tokens > tb.ratePerSecond {
+		tb.tokens = tb.ratePerSecond
+	}
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// picker implements the drop, circuit breaking, load reporting, and outlier
+// detection ejection logic on top of the child policy's picker.
+type picker struct {
+	drops     map[string]*dropper
+	s         balancer.State
+	loadStore *loadStoreWrapper
+	counter   *xdsclient.ClusterRequestsCounter
+	countMax  uint32
+	ejections *ejectionTracker // nil when outlier detection is disabled.
+	parent    *clusterImplBalancer
+}
+
+// refreshDrops reconciles an existing set of per-category droppers against a
+// new list of DropConfigs. A dropper is reused as-is when its category's
+// config is unchanged, so that a tokenBucket's accumulated tokens survive
+// config updates that don't actually affect it; it is rebuilt when the
+// config for its category changes, and dropped entirely when its category
+// is no longer present.
+func refreshDrops(existing map[string]*dropper, cfgs []DropConfig) map[string]*dropper {
+	drops := make(map[string]*dropper, len(cfgs))
+	for _, c := range cfgs {
+		if d, ok := existing[c.Category]; ok && dropConfigEqual(d.config, c) {
+			drops[c.Category] = d
+			continue
+		}
+		drops[c.Category] = newDropper(c)
+	}
+	return drops
+}
+
+// dropConfigEqual reports whether a and b specify the same drop behavior.
+// It can't use == directly because MaxRequestsPerSecond is a pointer that is
+// freshly allocated by json.Unmarshal on every config update.
+func dropConfigEqual(a, b DropConfig) bool {
+	if a.Category != b.Category || a.RequestsPerMillion != b.RequestsPerMillion {
+		return false
+	}
+	switch {
+	case a.MaxRequestsPerSecond == nil && b.MaxRequestsPerSecond == nil:
+		return true
+	case a.MaxRequestsPerSecond == nil || b.MaxRequestsPerSecond == nil:
+		return false
+	default:
+		return *a.MaxRequestsPerSecond == *b.MaxRequestsPerSecond
+	}
+}
+
+func newPicker(s balancer.State, config *LBConfig, drops map[string]*dropper, loadStore *loadStoreWrapper, ejections *ejectionTracker, parent *clusterImplBalancer) balancer.Picker {
+	var countMax uint32 = 1<<32 - 1
+	if config.MaxConcurrentRequests != nil {
+		countMax = *config.MaxConcurrentRequests
+	}
+	return &picker{
+		drops:     drops,
+		s:         s,
+		loadStore: loadStore,
+		counter:   xdsclient.GetClusterRequestsCounter(config.Cluster, config.EDSServiceName),
+		countMax:  countMax,
+		ejections: ejections,
+		parent:    parent,
+	}
+}
+
+func (d *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	for _, dp := range d.drops {
+		if dp.drop() {
+			d.loadStore.CallDropped(dp.category)
+			return balancer.PickResult{}, status.Errorf(codes.Unavailable, "RPC is dropped by category %q", dp.category)
+		}
+	}
+
+	if d.counter != nil && !d.counter.StartRequest(d.countMax) {
+		d.loadStore.CallDropped("circuit_breaking")
+		return balancer.PickResult{}, status.Errorf(codes.Unavailable, "RPC is dropped by circuit breaking")
+	}
+
+	pr, err := d.s.Picker.Pick(info)
+	if err != nil {
+		if d.counter != nil {
+			d.counter.EndRequest()
+		}
+		return pr, err
+	}
+
+	// The child policy is told about an ejection via a synthetic
+	// TRANSIENT_FAILURE SubConnState (see clusterImplBalancer.run), so it
+	// should stop returning an ejected SubConn on its own; this check is a
+	// backstop for the brief window between eject/un-eject and the child
+	// acting on that synthetic state change.
+	if d.ejections != nil && d.ejections.isEjected(pr.SubConn) {
+		if d.counter != nil {
+			d.counter.EndRequest()
+		}
+		d.loadStore.CallDropped("outlier_detection")
+		return balancer.PickResult{}, status.Errorf(codes.Unavailable, "RPC's subconn was ejected by outlier detection")
+	}
+
+	locality := d.parent.localityForSubConn(pr.SubConn)
+	d.loadStore.CallStarted(locality)
+	if d.ejections != nil {
+		d.ejections.callStarted(pr.SubConn)
+	}
+	childDone := pr.Done
+	pr.Done = func(info balancer.DoneInfo) {
+		if d.counter != nil {
+			d.counter.EndRequest()
+		}
+		d.loadStore.CallFinished(locality, info.Err)
+		if d.ejections != nil {
+			d.ejections.callFinished(pr.SubConn, info.Err)
+		}
+		if childDone != nil {
+			childDone(info)
+		}
+	}
+	return pr, nil
+}
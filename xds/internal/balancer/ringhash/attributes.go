@@ -0,0 +1,39 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package ringhash
+
+import (
+	"github.com/qiaohao9/grpc/resolver"
+)
+
+type addrWeightKey struct{}
+
+// SetAddrInfo returns a copy of addr with weight attached, for the
+// cluster_resolver (or any other producer of addresses feeding this
+// balancer) to communicate each endpoint's load-balancing weight.
+func SetAddrInfo(addr resolver.Address, weight uint32) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(addrWeightKey{}, weight)
+	return addr
+}
+
+// getAddrInfo returns the weight attached to addr via SetAddrInfo, if any.
+func getAddrInfo(addr resolver.Address) (uint32, bool) {
+	w, ok := addr.BalancerAttributes.Value(addrWeightKey{}).(uint32)
+	return w, ok
+}
@@ -0,0 +1,60 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package ringhash
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/qiaohao9/grpc/serviceconfig"
+)
+
+const (
+	defaultMinRingSize = 1024
+	defaultMaxRingSize = 4096
+
+	// ringSizeCap bounds maxRingSize, mirroring Envoy's hard limit on ring
+	// construction cost.
+	ringSizeCap = 8 * 1024 * 1024
+)
+
+// LBConfig is the balancer config for the ring_hash balancer.
+type LBConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	MinRingSize uint64 `json:"minRingSize,omitempty"`
+	MaxRingSize uint64 `json:"maxRingSize,omitempty"`
+}
+
+func parseConfig(c json.RawMessage) (*LBConfig, error) {
+	cfg := &LBConfig{MinRingSize: defaultMinRingSize, MaxRingSize: defaultMaxRingSize}
+	if err := json.Unmarshal(c, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MinRingSize > ringSizeCap {
+		return nil, fmt.Errorf("ring_hash: minRingSize %v greater than max supported value %v", cfg.MinRingSize, ringSizeCap)
+	}
+	if cfg.MaxRingSize > ringSizeCap {
+		return nil, fmt.Errorf("ring_hash: maxRingSize %v greater than max supported value %v", cfg.MaxRingSize, ringSizeCap)
+	}
+	if cfg.MinRingSize > cfg.MaxRingSize {
+		return nil, fmt.Errorf("ring_hash: minRingSize %v greater than maxRingSize %v", cfg.MinRingSize, cfg.MaxRingSize)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,62 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package ringhash
+
+import (
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/connectivity"
+	"github.com/qiaohao9/grpc/status"
+	xdsinternal "github.com/qiaohao9/grpc/xds/internal"
+)
+
+// picker implements Envoy's ring_hash picking algorithm: the request hash
+// (computed upstream by the xds resolver's configSelector and attached to
+// the RPC context) selects a starting point on the ring; picking then walks
+// forward from there until it finds a READY subConn, lazily connecting any
+// IDLE one it passes over along the way.
+type picker struct {
+	ring *ring
+}
+
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(p.ring.entries) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	requestHash, ok := xdsinternal.GetRequestHash(info.Ctx)
+	if !ok {
+		return balancer.PickResult{}, status.Error(codes.Internal, "ring_hash: no request hash found in context; is a ring_hash-compatible resolver configured?")
+	}
+
+	start := p.ring.pick(requestHash)
+	for i := 0; i < len(p.ring.entries); i++ {
+		e := p.ring.entries[(start+i)%len(p.ring.entries)]
+		switch e.sc.connectivityState() {
+		case connectivity.Ready:
+			return balancer.PickResult{SubConn: e.sc.sc}, nil
+		case connectivity.Idle:
+			e.sc.connectIfIdle()
+		}
+	}
+	// Every entry on the ring was scanned without finding a READY subConn;
+	// the aggregated balancer state (CONNECTING or TRANSIENT_FAILURE) drives
+	// whether the RPC is queued or failed outright, so let the caller treat
+	// this as "not yet available".
+	return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+}
@@ -0,0 +1,126 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package ringhash
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	xxhash "github.com/cespare/xxhash/v2"
+)
+
+// ringEntry is one point on the consistent-hash ring: a hash value and the
+// subConn it routes to. A single subConn contributes multiple ringEntry
+// values (its virtual nodes), proportional to its weight.
+type ringEntry struct {
+	hash uint64
+	sc   *subConn
+}
+
+// ring is the sorted set of points that the picker binary-searches to find
+// the subConn responsible for a given request hash.
+type ring struct {
+	entries []*ringEntry
+}
+
+// newRing builds a ring from subConns, giving each one a number of virtual
+// nodes proportional to its weight. The ring is sized so that even the
+// least-weighted subConn gets a whole number of virtual nodes out of
+// minRingSize, scaling up from there - capped at maxRingSize - the more the
+// weights are skewed relative to each other; a cluster with uniform
+// weights stays at (approximately) minRingSize entries. Each virtual node
+// i for an address is hashed as "<address>_<i>".
+func newRing(subConns []*subConn, minRingSize, maxRingSize uint64) *ring {
+	if len(subConns) == 0 {
+		return &ring{}
+	}
+
+	min := minRingSize
+	if min == 0 {
+		min = defaultMinRingSize
+	}
+	if maxRingSize < min {
+		maxRingSize = min
+	}
+
+	var totalWeight uint64
+	for _, sc := range subConns {
+		w := sc.weight
+		if w == 0 {
+			w = 1
+		}
+		totalWeight += uint64(w)
+	}
+
+	minNormalizedWeight := 1.0
+	for _, sc := range subConns {
+		w := sc.weight
+		if w == 0 {
+			w = 1
+		}
+		if normalized := float64(w) / float64(totalWeight); normalized < minNormalizedWeight {
+			minNormalizedWeight = normalized
+		}
+	}
+
+	// Scale the ring up from min so the least-weighted subConn still gets
+	// a whole virtual node per minNormalizedWeight share of it, e.g. a
+	// subConn with 1% of the total weight needs a ring 100x min to get
+	// even one virtual node; beyond maxRingSize that scaling is capped,
+	// trading exact proportionality for a bounded ring size.
+	scale := math.Ceil(minNormalizedWeight*float64(min)) / minNormalizedWeight
+	size := uint64(math.Ceil(scale))
+	if size < min {
+		size = min
+	}
+	if size > maxRingSize {
+		size = maxRingSize
+	}
+
+	entries := make([]*ringEntry, 0, size)
+	for _, sc := range subConns {
+		w := sc.weight
+		if w == 0 {
+			w = 1
+		}
+		n := int(math.Ceil(float64(w) * float64(size) / float64(totalWeight)))
+		if n < 1 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			h := xxhash.Sum64String(fmt.Sprintf("%s_%d", sc.addr, i))
+			entries = append(entries, &ringEntry{hash: h, sc: sc})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].hash < entries[j].hash })
+	return &ring{entries: entries}
+}
+
+// pick returns the index of the ring entry responsible for requestHash: the
+// first entry whose hash is >= requestHash, wrapping around to the start of
+// the ring if requestHash is greater than every entry's hash.
+func (r *ring) pick(requestHash uint64) int {
+	idx := sort.Search(len(r.entries), func(i int) bool { return r.entries[i].hash >= requestHash })
+	if idx == len(r.entries) {
+		idx = 0
+	}
+	return idx
+}
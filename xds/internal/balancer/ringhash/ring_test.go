@@ -0,0 +1,63 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package ringhash
+
+import "testing"
+
+func scWithWeight(addr string, weight uint32) *subConn {
+	return &subConn{addr: addr, weight: weight}
+}
+
+func TestNewRingSizeUniformWeights(t *testing.T) {
+	// Every subConn carries the same weight, so the ring shouldn't need to
+	// scale up past minRingSize to give each one a whole number of virtual
+	// nodes.
+	scs := []*subConn{scWithWeight("1", 1), scWithWeight("2", 1)}
+	r := newRing(scs, 100, 4096)
+	if len(r.entries) < 100 || len(r.entries) > 200 {
+		t.Fatalf("newRing() produced %d entries for uniform weights, want close to 100", len(r.entries))
+	}
+}
+
+func TestNewRingSizeScalesWithWeightSkew(t *testing.T) {
+	// The second subConn is 99x as heavy as the first, so a ring sized at
+	// minRingSize would round the first subConn up to a single virtual
+	// node out of very few total - newRing should scale the ring up
+	// (bounded by maxRingSize) so the skew is represented more precisely.
+	scs := []*subConn{scWithWeight("light", 1), scWithWeight("heavy", 99)}
+	got := len(newRing(scs, 2, 4096).entries)
+	if want := 2; got <= want {
+		t.Fatalf("newRing() with skewed weights produced %d entries, want more than minRingSize (%d)", got, want)
+	}
+}
+
+func TestNewRingSizeCappedAtMaxRingSize(t *testing.T) {
+	// However extreme the skew, the base ring size newRing scales to must
+	// never grow past maxRingSize: it trades exact proportionality for a
+	// bounded construction cost once the skew would otherwise blow past
+	// it. The final entry count can still exceed maxRingSize by up to one
+	// virtual node per subConn, since every subConn is rounded up to at
+	// least one node regardless of how small its share of the ring is.
+	scs := []*subConn{scWithWeight("light", 1), scWithWeight("heavy", 1000000)}
+	const maxRingSize = 512
+	got := len(newRing(scs, 2, maxRingSize).entries)
+	if max := maxRingSize + uint64(len(scs)); uint64(got) > max {
+		t.Fatalf("newRing() produced %d entries, want at most %d", got, max)
+	}
+}
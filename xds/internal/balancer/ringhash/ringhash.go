@@ -0,0 +1,198 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package ringhash implements the ring_hash balancer, Envoy's consistent
+// hashing load balancing policy. It consumes the per-RPC request hash
+// computed by the xds resolver's configSelector to route requests that
+// share a hash to the same backend whenever that backend is available.
+package ringhash
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/connectivity"
+	"github.com/qiaohao9/grpc/grpclog"
+	internalgrpclog "github.com/qiaohao9/grpc/internal/grpclog"
+	"github.com/qiaohao9/grpc/resolver"
+	"github.com/qiaohao9/grpc/serviceconfig"
+	xdsbalancer "github.com/qiaohao9/grpc/xds/balancer"
+)
+
+// Name is the name of the ring_hash balancer.
+const Name = "ring_hash_experimental"
+
+var logger = grpclog.Component("xds")
+
+func init() {
+	balancer.Register(bb{})
+	// Also register with the xDS balancer registry so that ring_hash can be
+	// selected by name as an xds_cluster_impl child policy the same way a
+	// user-provided policy registered there would be.
+	xdsbalancer.Register(Name, bb{}, func(c json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+		return parseConfig(c)
+	})
+}
+
+type bb struct{}
+
+func (bb) Build(cc balancer.ClientConn, bOpts balancer.BuildOptions) balancer.Balancer {
+	b := &ringhashBalancer{
+		cc:       cc,
+		subConns: make(map[string]*subConn),
+	}
+	b.logger = internalgrpclog.NewPrefixLogger(logger, fmt.Sprintf("[ring-hash-lb %p] ", b))
+	return b
+}
+
+func (bb) Name() string {
+	return Name
+}
+
+func (bb) ParseConfig(c json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	return parseConfig(c)
+}
+
+// ringhashBalancer is a leaf balancer (it talks directly to addresses, not
+// to a child policy) that builds a consistent-hash ring over its SubConns
+// and hands out a picker that walks that ring per gRFC A61.
+type ringhashBalancer struct {
+	cc     balancer.ClientConn
+	logger *internalgrpclog.PrefixLogger
+
+	config   *LBConfig
+	subConns map[string]*subConn // keyed by resolver.Address.Addr
+	ring     *ring
+}
+
+func (b *ringhashBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	cfg, ok := s.BalancerConfig.(*LBConfig)
+	if !ok {
+		return fmt.Errorf("unexpected balancer config with type: %T", s.BalancerConfig)
+	}
+	b.config = cfg
+
+	newAddrs := make(map[string]resolver.Address, len(s.ResolverState.Addresses))
+	for _, a := range s.ResolverState.Addresses {
+		newAddrs[a.Addr] = a
+	}
+
+	for addr, sc := range b.subConns {
+		if _, ok := newAddrs[addr]; !ok {
+			b.cc.RemoveSubConn(sc.sc)
+			delete(b.subConns, addr)
+		}
+	}
+	for addr, a := range newAddrs {
+		if _, ok := b.subConns[addr]; ok {
+			continue
+		}
+		newSC, err := b.cc.NewSubConn([]resolver.Address{a}, balancer.NewSubConnOptions{})
+		if err != nil {
+			b.logger.Warningf("Failed to create new SubConn for address %v: %v", a, err)
+			continue
+		}
+		sc := &subConn{addr: addr, weight: addrWeight(a), sc: newSC, state: connectivity.Idle}
+		b.subConns[addr] = sc
+	}
+
+	b.rebuildRing()
+	b.regeneratePicker()
+	return nil
+}
+
+func (b *ringhashBalancer) rebuildRing() {
+	scs := make([]*subConn, 0, len(b.subConns))
+	for _, sc := range b.subConns {
+		scs = append(scs, sc)
+	}
+	b.ring = newRing(scs, b.config.MinRingSize, b.config.MaxRingSize)
+}
+
+func (b *ringhashBalancer) ResolverError(err error) {
+	if len(b.subConns) == 0 {
+		b.cc.UpdateState(balancer.State{ConnectivityState: connectivity.TransientFailure, Picker: nil})
+	}
+}
+
+func (b *ringhashBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	for _, entry := range b.subConns {
+		if entry.sc == sc {
+			entry.updateConnectivityState(s.ConnectivityState)
+			break
+		}
+	}
+	b.regeneratePicker()
+}
+
+// regeneratePicker pushes a new picker built from the current ring, along
+// with the connectivity state aggregated across all SubConns per gRFC A42:
+// READY if any SubConn is READY; else TRANSIENT_FAILURE if 2 or more
+// SubConns are in TRANSIENT_FAILURE; else CONNECTING if any SubConn is
+// CONNECTING; else IDLE if any SubConn is IDLE; else TRANSIENT_FAILURE.
+func (b *ringhashBalancer) regeneratePicker() {
+	var numReady, numConnecting, numIdle, numTF int
+	for _, sc := range b.subConns {
+		switch sc.connectivityState() {
+		case connectivity.Ready:
+			numReady++
+		case connectivity.Connecting:
+			numConnecting++
+		case connectivity.Idle:
+			numIdle++
+		case connectivity.TransientFailure:
+			numTF++
+		}
+	}
+
+	var aggState connectivity.State
+	switch {
+	case numReady > 0:
+		aggState = connectivity.Ready
+	case numTF >= 2:
+		aggState = connectivity.TransientFailure
+	case numConnecting > 0:
+		aggState = connectivity.Connecting
+	case numIdle > 0:
+		aggState = connectivity.Idle
+	default:
+		aggState = connectivity.TransientFailure
+	}
+
+	b.cc.UpdateState(balancer.State{
+		ConnectivityState: aggState,
+		Picker:            &picker{ring: b.ring},
+	})
+}
+
+func (b *ringhashBalancer) Close() {}
+
+func (b *ringhashBalancer) ExitIdle() {
+	for _, sc := range b.subConns {
+		sc.connectIfIdle()
+	}
+}
+
+// addrWeight returns the weight attached to a, or 1 if it has none.
+func addrWeight(a resolver.Address) uint32 {
+	if w, ok := getAddrInfo(a); ok {
+		return w
+	}
+	return 1
+}
@@ -19,10 +19,13 @@
 package ringhash
 
 import (
+	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/qiaohao9/grpc/connectivity"
+	"github.com/qiaohao9/grpc/internal/grpctest"
 	"github.com/qiaohao9/grpc/xds/internal/testutils"
 )
 
@@ -36,3 +39,59 @@ var (
 const (
 	defaultTestShortTimeout = 10 * time.Millisecond
 )
+
+type s struct {
+	grpctest.Tester
+}
+
+func Test(t *testing.T) {
+	grpctest.RunSubTests(t, s{})
+}
+
+// TestNewRing verifies that newRing produces a sorted ring with each
+// subConn contributing virtual nodes roughly proportional to its weight,
+// bounded by minRingSize/maxRingSize.
+func (s) TestNewRing(t *testing.T) {
+	scA := &subConn{addr: "a", weight: 1, sc: &testutils.TestSubConn{}}
+	scB := &subConn{addr: "b", weight: 3, sc: &testutils.TestSubConn{}}
+
+	r := newRing([]*subConn{scA, scB}, 100, 1000)
+	if len(r.entries) == 0 {
+		t.Fatalf("newRing produced an empty ring")
+	}
+	for i := 1; i < len(r.entries); i++ {
+		if r.entries[i-1].hash > r.entries[i].hash {
+			t.Fatalf("ring entries are not sorted by hash at index %d", i)
+		}
+	}
+
+	var countA, countB int
+	for _, e := range r.entries {
+		switch e.sc {
+		case scA:
+			countA++
+		case scB:
+			countB++
+		}
+	}
+	if countA == 0 || countB == 0 {
+		t.Fatalf("expected both subConns to get at least one virtual node, got countA=%d countB=%d", countA, countB)
+	}
+	if countB <= countA {
+		t.Fatalf("expected the 3x-weighted subConn to get more virtual nodes than the 1x one, got countA=%d countB=%d", countA, countB)
+	}
+}
+
+// TestRingPickWraps verifies that pick wraps around to the first entry when
+// the request hash is greater than every entry's hash.
+func (s) TestRingPickWraps(t *testing.T) {
+	sc := &subConn{addr: "a", weight: 1, sc: &testutils.TestSubConn{}, state: connectivity.Ready}
+	r := newRing([]*subConn{sc}, 4, 4)
+	if len(r.entries) == 0 {
+		t.Fatalf("newRing produced an empty ring")
+	}
+	maxHash := r.entries[len(r.entries)-1].hash
+	if got := r.pick(maxHash + 1); got != 0 {
+		t.Fatalf("pick(maxHash+1) = %d, want 0 (wrap around to the start)", got)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package ringhash
+
+import (
+	"sync"
+
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/connectivity"
+)
+
+// subConn wraps a balancer.SubConn and the address it was created for, and
+// tracks the most recently reported connectivity state so the picker can
+// make ring-walking decisions (and lazily trigger a connection attempt on
+// an IDLE entry) without blocking on the balancer's own state.
+type subConn struct {
+	addr   string
+	weight uint32
+	sc     balancer.SubConn
+
+	mu    sync.Mutex
+	state connectivity.State
+}
+
+func (sc *subConn) connectivityState() connectivity.State {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.state
+}
+
+func (sc *subConn) updateConnectivityState(s connectivity.State) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.state = s
+}
+
+// connectIfIdle lazily kicks off a connection attempt the first time the
+// picker walks over an IDLE entry in the ring.
+func (sc *subConn) connectIfIdle() {
+	sc.mu.Lock()
+	idle := sc.state == connectivity.Idle
+	if idle {
+		sc.state = connectivity.Connecting
+	}
+	sc.mu.Unlock()
+	if idle {
+		sc.sc.Connect()
+	}
+}
@@ -0,0 +1,336 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package fault implements the Envoy fault injection HTTP filter
+// (envoy.filters.http.fault) for the xDS-enabled gRPC server. An Engine
+// built from a single HTTPFault proto, whether installed at the
+// connection level or as a per-route FilterConfigOverride, is consulted
+// by the server interceptor pipeline before an RPC reaches application
+// code, and can delay it, abort it with a configured status, or both. A
+// per-route override takes precedence over the connection-level Engine
+// for RPCs matching that route, via
+// xds/internal/server.connWrapper.InterceptorsForRoute.
+package fault
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	v3faultcommonpb "github.com/envoyproxy/go-control-plane/envoy/config/common/fault/v3"
+	v3faultpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	v3typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/metadata"
+	"github.com/qiaohao9/grpc/status"
+	"github.com/qiaohao9/grpc/xds/internal/httpfilter"
+)
+
+// faultTypeURL is the proto message type URL, as found in a
+// HttpConnectionManager's HttpFilter.ConfigType, of the fault injection
+// HTTP filter that this package registers itself for.
+const faultTypeURL = "type.googleapis.com/envoy.extensions.filters.http.fault.v3.HTTPFault"
+
+func init() {
+	httpfilter.Register(builder{})
+}
+
+// builder implements httpfilter.Filter and httpfilter.ServerInterceptorBuilder,
+// turning a fault injection HTTP filter's connection-level configuration
+// into a ServerInterceptor that the xds-enabled server's interceptor
+// pipeline runs for every RPC accepted on a connection configured with
+// this filter.
+type builder struct{}
+
+func (builder) TypeURLs() []string { return []string{faultTypeURL} }
+
+func (builder) BuildServerInterceptor(cfg proto.Message) (httpfilter.ServerInterceptor, error) {
+	pb, ok := cfg.(*v3faultpb.HTTPFault)
+	if !ok {
+		return nil, fmt.Errorf("fault: unsupported config type %T", cfg)
+	}
+	engine, err := New(pb)
+	if err != nil {
+		return nil, err
+	}
+	return (*serverInterceptor)(engine), nil
+}
+
+// serverInterceptor adapts an *Engine to httpfilter.ServerInterceptor.
+type serverInterceptor Engine
+
+// AllowRPC implements httpfilter.ServerInterceptor by applying the fault
+// injection Engine underlying si to the RPC identified by ctx.
+func (si *serverInterceptor) AllowRPC(ctx context.Context) error {
+	return (*Engine)(si).Apply(ctx)
+}
+
+// Default names of the incoming request headers consulted when a delay or
+// abort is configured to read its percentage or status from the request
+// rather than from static proto fields, matching Envoy's x-envoy-fault-*
+// convention.
+const (
+	headerAbortPercent    = "x-envoy-fault-abort-request"
+	headerAbortGRPCStatus = "x-envoy-fault-abort-grpc-request"
+	// headerDelayDurationMS carries both the delay duration in
+	// milliseconds and, by its mere presence, a 100% trigger percentage,
+	// per Envoy's x-envoy-fault-delay-request semantics.
+	headerDelayDurationMS = "x-envoy-fault-delay-request"
+)
+
+// delaySpec is the parsed, evaluable form of a FaultDelay.
+type delaySpec struct {
+	fixed time.Duration
+	pct   percentage
+	// fromHeader is true if the FaultDelay's duration and trigger
+	// percentage both come from the incoming request's headerDelayPercent
+	// header rather than fixed and pct.
+	fromHeader bool
+}
+
+// abortSpec is the parsed, evaluable form of a FaultAbort.
+type abortSpec struct {
+	code codes.Code
+	pct  percentage
+	// fromHeader is true if the FaultAbort's status and trigger percentage
+	// both come from the incoming request's headerAbortPercent /
+	// headerAbortGRPCStatus headers rather than code and pct.
+	fromHeader bool
+}
+
+// percentage is a FractionalPercent reduced to a numerator out of
+// denominator, e.g. {50, 100} for 50%.
+type percentage struct {
+	numerator   uint32
+	denominator uint32
+}
+
+func (p percentage) triggers(roll uint32) bool {
+	if p.denominator == 0 {
+		return false
+	}
+	return roll%p.denominator < p.numerator
+}
+
+func newPercentage(fp *v3typepb.FractionalPercent) percentage {
+	var denom uint32 = 100
+	switch fp.GetDenominator() {
+	case v3typepb.FractionalPercent_HUNDRED:
+		denom = 100
+	case v3typepb.FractionalPercent_TEN_THOUSAND:
+		denom = 10000
+	case v3typepb.FractionalPercent_MILLION:
+		denom = 1000000
+	}
+	return percentage{numerator: fp.GetNumerator(), denominator: denom}
+}
+
+// Engine evaluates one Envoy HTTPFault configuration against incoming
+// RPCs. It is safe for concurrent use after construction by New: delay and
+// abort are immutable, and rnd is guarded by mu since Apply runs
+// concurrently for every RPC on the filter chain's connection.
+type Engine struct {
+	delay *delaySpec
+	abort *abortSpec
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// New builds an Engine from cfg. A nil cfg, or one with neither Delay nor
+// Abort set, yields an Engine that never delays or aborts an RPC.
+func New(cfg *v3faultpb.HTTPFault) (*Engine, error) {
+	e := &Engine{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	if d := cfg.GetDelay(); d != nil {
+		spec, err := newDelaySpec(d)
+		if err != nil {
+			return nil, err
+		}
+		e.delay = spec
+	}
+	if a := cfg.GetAbort(); a != nil {
+		spec, err := newAbortSpec(a)
+		if err != nil {
+			return nil, err
+		}
+		e.abort = spec
+	}
+	return e, nil
+}
+
+// SetRandForTesting replaces e's source of randomness with r, so that
+// tests can make percentage-gated delay/abort decisions deterministic.
+func (e *Engine) SetRandForTesting(r *rand.Rand) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rnd = r
+}
+
+// roll returns the next uint32 drawn from e's source of randomness,
+// serialized by mu since the same Engine's rnd is shared across
+// concurrently-evaluated RPCs.
+func (e *Engine) roll() uint32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rnd.Uint32()
+}
+
+func newDelaySpec(d *v3faultcommonpb.FaultDelay) (*delaySpec, error) {
+	spec := &delaySpec{pct: newPercentage(d.GetPercentage())}
+	switch d.GetFaultDelaySecifier().(type) {
+	case *v3faultcommonpb.FaultDelay_HeaderDelay_:
+		spec.fromHeader = true
+	default:
+		spec.fixed = d.GetFixedDelay().AsDuration()
+	}
+	return spec, nil
+}
+
+func newAbortSpec(a *v3faultpb.FaultAbort) (*abortSpec, error) {
+	spec := &abortSpec{pct: newPercentage(a.GetPercentage())}
+	switch e := a.GetErrorType().(type) {
+	case *v3faultpb.FaultAbort_HeaderAbort_:
+		spec.fromHeader = true
+	case *v3faultpb.FaultAbort_GrpcStatus:
+		spec.code = codes.Code(e.GrpcStatus)
+	case *v3faultpb.FaultAbort_HttpStatus:
+		spec.code = codes.Code(httpStatusToGRPCCode(e.HttpStatus))
+	}
+	return spec, nil
+}
+
+// httpStatusToGRPCCode maps an HTTP abort status to the gRPC code the
+// server interceptor pipeline must return, since a server-side RPC can
+// only be failed with a grpc status. This mirrors the small subset of
+// codes.google.golang.org/grpc's own http2_client status translation that
+// fault injection configurations in practice use.
+func httpStatusToGRPCCode(httpStatus int32) codes.Code {
+	switch httpStatus {
+	case 400:
+		return codes.Internal
+	case 401:
+		return codes.Unauthenticated
+	case 403:
+		return codes.PermissionDenied
+	case 404:
+		return codes.Unimplemented
+	case 429, 502, 503, 504:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
+
+// Apply evaluates e against the RPC described by ctx: it sleeps for any
+// triggered delay (returning early if ctx is done first), then returns a
+// non-nil error built from the triggered abort, if any. Callers install
+// this ahead of the RPC handler in the interceptor chain and return
+// immediately if the returned error is non-nil. A nil Engine never delays
+// or aborts, preserving current behavior for filter chains with no fault
+// filter configured.
+func (e *Engine) Apply(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if e.delay != nil {
+		if d, ok := e.triggeredDelay(md); ok {
+			t := time.NewTimer(d)
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	if e.abort != nil {
+		if code, ok := e.triggeredAbort(md); ok {
+			return status.Error(code, "rpc aborted by fault injection")
+		}
+	}
+	return nil
+}
+
+func (e *Engine) triggeredDelay(md metadata.MD) (time.Duration, bool) {
+	d := e.delay
+	if d.fromHeader {
+		ms, pct, ok := headerIntPair(md, headerDelayDurationMS)
+		if !ok || !(percentage{numerator: uint32(pct), denominator: 100}).triggers(e.roll()) {
+			return 0, false
+		}
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	if !d.pct.triggers(e.roll()) {
+		return 0, false
+	}
+	return d.fixed, true
+}
+
+func (e *Engine) triggeredAbort(md metadata.MD) (codes.Code, bool) {
+	a := e.abort
+	if a.fromHeader {
+		pctStr := firstHeader(md, headerAbortPercent)
+		grpcStatusStr := firstHeader(md, headerAbortGRPCStatus)
+		pct, err1 := strconv.Atoi(pctStr)
+		code, err2 := strconv.Atoi(grpcStatusStr)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		if !(percentage{numerator: uint32(pct), denominator: 100}).triggers(e.roll()) {
+			return 0, false
+		}
+		return codes.Code(code), true
+	}
+	if !a.pct.triggers(e.roll()) {
+		return 0, false
+	}
+	return a.code, true
+}
+
+func firstHeader(md metadata.MD, name string) string {
+	vals := md.Get(name)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// headerIntPair reads the single headerDelayDurationMS header value,
+// which Envoy encodes as just the delay duration in milliseconds; the
+// percentage to apply it at is read from the same header's presence,
+// i.e. if the header is set the delay always triggers (percentage 100),
+// matching Envoy's documented x-envoy-fault-delay-request semantics.
+func headerIntPair(md metadata.MD, name string) (ms int, pct int, ok bool) {
+	v := firstHeader(md, name)
+	if v == "" {
+		return 0, 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, 0, false
+	}
+	return n, 100, true
+}
@@ -0,0 +1,128 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package fault
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	v3faultcommonpb "github.com/envoyproxy/go-control-plane/envoy/config/common/fault/v3"
+	v3faultpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	v3typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/metadata"
+	"github.com/qiaohao9/grpc/status"
+)
+
+func fullPercentage() *v3typepb.FractionalPercent {
+	return &v3typepb.FractionalPercent{Numerator: 100, Denominator: v3typepb.FractionalPercent_HUNDRED}
+}
+
+func TestEngine_NilEngineNoOp(t *testing.T) {
+	var e *Engine
+	if err := e.Apply(context.Background()); err != nil {
+		t.Fatalf("nil Engine.Apply() = %v, want nil", err)
+	}
+}
+
+func TestEngine_AbortAlwaysTriggers(t *testing.T) {
+	e, err := New(&v3faultpb.HTTPFault{
+		Abort: &v3faultpb.FaultAbort{
+			ErrorType:  &v3faultpb.FaultAbort_GrpcStatus{GrpcStatus: uint32(codes.Unavailable)},
+			Percentage: fullPercentage(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if err := e.Apply(context.Background()); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Apply() = %v, want codes.Unavailable", err)
+	}
+}
+
+func TestEngine_AbortNeverTriggersAtZeroPercent(t *testing.T) {
+	e, err := New(&v3faultpb.HTTPFault{
+		Abort: &v3faultpb.FaultAbort{
+			ErrorType:  &v3faultpb.FaultAbort_GrpcStatus{GrpcStatus: uint32(codes.Unavailable)},
+			Percentage: &v3typepb.FractionalPercent{Numerator: 0, Denominator: v3typepb.FractionalPercent_HUNDRED},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if err := e.Apply(context.Background()); err != nil {
+		t.Fatalf("Apply() = %v, want nil at 0%% trigger percentage", err)
+	}
+}
+
+func TestEngine_DelayAlwaysTriggers(t *testing.T) {
+	e, err := New(&v3faultpb.HTTPFault{
+		Delay: &v3faultcommonpb.FaultDelay{
+			FaultDelaySecifier: &v3faultcommonpb.FaultDelay_FixedDelay{FixedDelay: &durationpb.Duration{Nanos: int32(50 * time.Millisecond)}},
+			Percentage:         fullPercentage(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	start := time.Now()
+	if err := e.Apply(context.Background()); err != nil {
+		t.Fatalf("Apply() = %v, want nil", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("Apply() returned after %v, want at least 50ms", elapsed)
+	}
+}
+
+func TestEngine_DelayRespectsContextCancellation(t *testing.T) {
+	e, err := New(&v3faultpb.HTTPFault{
+		Delay: &v3faultcommonpb.FaultDelay{
+			FaultDelaySecifier: &v3faultcommonpb.FaultDelay_FixedDelay{FixedDelay: &durationpb.Duration{Nanos: int32(time.Hour)}},
+			Percentage:         fullPercentage(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := e.Apply(ctx); err == nil {
+		t.Fatalf("Apply() = nil, want context deadline error")
+	}
+}
+
+func TestEngine_AbortHeaderOverride(t *testing.T) {
+	e, err := New(&v3faultpb.HTTPFault{
+		Abort: &v3faultpb.FaultAbort{ErrorType: &v3faultpb.FaultAbort_HeaderAbort_{HeaderAbort: &v3faultpb.FaultAbort_HeaderAbort{}}},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	e.SetRandForTesting(rand.New(rand.NewSource(1)))
+
+	md := metadata.Pairs(headerAbortPercent, "100", headerAbortGRPCStatus, "14") // 14 == codes.Unavailable
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	if err := e.Apply(ctx); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Apply() = %v, want codes.Unavailable per header override", err)
+	}
+}
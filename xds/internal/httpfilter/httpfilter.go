@@ -0,0 +1,89 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package httpfilter is a registry of server-side xDS HTTP filter
+// implementations, keyed by the Envoy filter name configured in a
+// filter chain's HttpConnectionManager (e.g. "rbac", "fault"). The
+// listenerWrapper in xds/internal/server consults this registry, via
+// Get, to turn the HTTP filters configured on a matched filter chain
+// into the ServerInterceptor chain it runs for every RPC accepted on
+// that connection.
+package httpfilter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ServerInterceptor is implemented by an HTTP filter's built, per-filter-
+// chain instance. AllowRPC is invoked before an RPC's handler runs, with
+// the incoming RPC's context; a non-nil error fails the RPC with that
+// error and skips its handler.
+type ServerInterceptor interface {
+	AllowRPC(ctx context.Context) error
+}
+
+// Filter is the interface implemented by an HTTP filter implementation
+// registered with this package. It is also the lookup key for Builder
+// objects held by the registry: the builder IS the Filter.
+type Filter interface {
+	// TypeURLs returns the proto message type URL(s), as found in the
+	// HttpConnectionManager's HttpFilter.ConfigType, that this Filter
+	// knows how to build a ServerInterceptor from.
+	TypeURLs() []string
+}
+
+// ServerInterceptorBuilder is implemented by a Filter that can build a
+// ServerInterceptor for use on the server side of an xDS-enabled gRPC
+// server.
+type ServerInterceptorBuilder interface {
+	// BuildServerInterceptor builds a ServerInterceptor from cfg, the
+	// filter's top-level (connection-scoped) configuration proto.
+	BuildServerInterceptor(cfg proto.Message) (ServerInterceptor, error)
+}
+
+var (
+	mu       sync.Mutex
+	registry = make(map[string]Filter)
+)
+
+// Register registers f under every name returned by f.TypeURLs, so that a
+// later call to Get with a matching type URL returns f. Registering two
+// Filters under the same type URL is a programming error and panics; it is
+// intended to be called from an init function.
+func Register(f Filter) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, typeURL := range f.TypeURLs() {
+		if _, ok := registry[typeURL]; ok {
+			panic(fmt.Sprintf("httpfilter: multiple filters registered for type URL %q", typeURL))
+		}
+		registry[typeURL] = f
+	}
+}
+
+// Get returns the Filter registered for typeURL, or nil if none is
+// registered.
+func Get(typeURL string) Filter {
+	mu.Lock()
+	defer mu.Unlock()
+	return registry[typeURL]
+}
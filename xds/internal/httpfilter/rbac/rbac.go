@@ -0,0 +1,498 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package rbac implements the Envoy RBAC (Role-Based Access Control) HTTP
+// filter (envoy.filters.http.rbac) for the xDS-enabled gRPC server. An
+// Engine built from a single RBAC proto, or from a connection-level engine
+// plus a per-route override, is consulted by xds(Unary|Stream)Interceptors
+// on every RPC and can deny it with codes.PermissionDenied before it
+// reaches application code.
+package rbac
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang/protobuf/proto"
+
+	v3rbacpb "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	v3rbachttppb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/rbac/v3"
+	v3matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/credentials"
+	"github.com/qiaohao9/grpc/grpclog"
+	"github.com/qiaohao9/grpc/metadata"
+	"github.com/qiaohao9/grpc/peer"
+	"github.com/qiaohao9/grpc/status"
+	"github.com/qiaohao9/grpc/xds/internal/httpfilter"
+)
+
+var logger = grpclog.Component("xds")
+
+// rbacTypeURL is the proto message type URL, as found in a
+// HttpConnectionManager's HttpFilter.ConfigType, of the RBAC HTTP filter
+// that this package registers itself for.
+const rbacTypeURL = "type.googleapis.com/envoy.extensions.filters.http.rbac.v3.RBAC"
+
+func init() {
+	httpfilter.Register(builder{})
+}
+
+// builder implements httpfilter.Filter and httpfilter.ServerInterceptorBuilder,
+// turning an RBAC HTTP filter's connection-level configuration into a
+// ServerInterceptor that the xds-enabled server's interceptor pipeline runs
+// for every RPC accepted on a connection configured with this filter.
+type builder struct{}
+
+func (builder) TypeURLs() []string { return []string{rbacTypeURL} }
+
+func (builder) BuildServerInterceptor(cfg proto.Message) (httpfilter.ServerInterceptor, error) {
+	pb, ok := cfg.(*v3rbachttppb.RBAC)
+	if !ok {
+		return nil, fmt.Errorf("rbac: unsupported config type %T", cfg)
+	}
+	engine, err := New(pb.GetRules())
+	if err != nil {
+		return nil, err
+	}
+	return &ServerInterceptor{engine: engine}, nil
+}
+
+// ServerInterceptor is the ServerInterceptor built by builder for a single
+// filter chain's RBAC configuration. It is returned as an
+// httpfilter.ServerInterceptor, but exposes SetAuditSink so that
+// xds.GRPCServer can wire in an AuditSink configured via
+// xds.RBACAuditSink, which the RBAC proto itself has no room for. A single
+// ServerInterceptor is shared by every RPC on the connection it was built
+// for, so SetAuditSink and AllowRPC can run concurrently; sink is guarded
+// accordingly.
+type ServerInterceptor struct {
+	engine *Engine
+	sink   atomic.Value // holds AuditSink
+}
+
+// SetAuditSink installs sink as the AuditSink every subsequent AllowRPC
+// call reports its Decision to. Safe to call concurrently with AllowRPC.
+func (si *ServerInterceptor) SetAuditSink(sink AuditSink) {
+	si.sink.Store(&sink)
+}
+
+// AllowRPC implements httpfilter.ServerInterceptor by evaluating si's
+// Engine against the RPC identified by ctx, using grpc.Method to recover
+// the RPC's full method name.
+func (si *ServerInterceptor) AllowRPC(ctx context.Context) error {
+	method, _ := grpc.Method(ctx)
+	var sink AuditSink
+	if v, ok := si.sink.Load().(*AuditSink); ok {
+		sink = *v
+	}
+	return si.engine.EvaluateAndAudit(ctx, method, sink)
+}
+
+// AuditSink receives a Decision for every RPC evaluated by an Engine,
+// whether allowed or denied. Implementations must not block; Engine invokes
+// LogDecision synchronously on the RPC path.
+type AuditSink interface {
+	// LogDecision is invoked once per RPC, after the matching policy (if
+	// any) has been determined.
+	LogDecision(Decision)
+}
+
+// Decision describes the outcome of evaluating an Engine against a single
+// RPC, for consumption by an AuditSink.
+type Decision struct {
+	// Allowed is true if the RPC was permitted to proceed.
+	Allowed bool
+	// Policy is the name of the policy that determined the outcome, or the
+	// empty string if no configured policy matched the RPC.
+	Policy string
+	// FullMethod is the RPC's fully-qualified method name, as reported by
+	// the incoming context.
+	FullMethod string
+	// Principal identifies the caller, derived from the authenticated peer
+	// certificate's SAN fields when available, else the peer address.
+	Principal string
+}
+
+// policy is the parsed, evaluable form of a v3rbacpb.Policy: an RPC matches
+// the policy if it matches at least one of permissions AND at least one of
+// principals.
+type policy struct {
+	permissions []matcher
+	principals  []matcher
+}
+
+func (p *policy) matches(rpc *rpcContext) bool {
+	return matchesAny(p.permissions, rpc) && matchesAny(p.principals, rpc)
+}
+
+func matchesAny(ms []matcher, rpc *rpcContext) bool {
+	for _, m := range ms {
+		if m(rpc) {
+			return true
+		}
+	}
+	return false
+}
+
+// matcher reports whether rpc satisfies some principal or permission
+// condition. Engine compiles every *v3rbacpb.Permission and
+// *v3rbacpb.Principal leaf, including the "and_ids"/"or_ids" composites,
+// down to a tree of matchers.
+type matcher func(*rpcContext) bool
+
+// rpcContext is the information about an incoming RPC that principal and
+// permission matchers are evaluated against.
+type rpcContext struct {
+	fullMethod string
+	headers    metadata.MD
+	destPort   uint32
+	sourceIP   net.IP
+	// authenticated is nil if the RPC's transport credentials did not
+	// authenticate the peer (e.g. no client certificate was presented).
+	authenticated *tls.ConnectionState
+}
+
+// Engine evaluates one Envoy RBAC configuration (a single policy action
+// plus its named policies) against incoming RPCs. It is immutable and safe
+// for concurrent use after construction by New.
+type Engine struct {
+	action   v3rbacpb.RBAC_Action
+	policies map[string]*policy
+	// order preserves proto map iteration determinism: the first matching
+	// policy in this slice, not map order, is reported to the AuditSink and
+	// in logs.
+	order []string
+}
+
+// New builds an Engine from cfg. An RBAC proto with no entries in its
+// Policies map is valid and, per Envoy semantics, matches no RPCs: with
+// action ALLOW this denies every RPC, and with action DENY this allows
+// every RPC.
+func New(cfg *v3rbacpb.RBAC) (*Engine, error) {
+	e := &Engine{
+		action:   cfg.GetAction(),
+		policies: make(map[string]*policy, len(cfg.GetPolicies())),
+	}
+	// Policies is a proto map, so iterate a name list built in whatever
+	// order range gives us once, then sort for determinism across builds.
+	for name, p := range cfg.GetPolicies() {
+		permissions, err := newPermissionMatchers(p.GetPermissions())
+		if err != nil {
+			return nil, fmt.Errorf("rbac: policy %q: %v", name, err)
+		}
+		principals, err := newPrincipalMatchers(p.GetPrincipals())
+		if err != nil {
+			return nil, fmt.Errorf("rbac: policy %q: %v", name, err)
+		}
+		e.policies[name] = &policy{permissions: permissions, principals: principals}
+		e.order = append(e.order, name)
+	}
+	sort.Strings(e.order)
+	return e, nil
+}
+
+// Evaluate reports whether an RPC described by ctx, headers and method
+// should be allowed, along with the name of the policy (if any) that
+// determined the outcome. A nil Engine allows every RPC with no
+// policy name, preserving the behavior of a filter chain with no RBAC
+// filter configured.
+func (e *Engine) Evaluate(ctx context.Context, method string) (allowed bool, policyName string) {
+	if e == nil {
+		return true, ""
+	}
+	rpc := &rpcContext{fullMethod: method}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		rpc.headers = md
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if tcpAddr, ok := p.LocalAddr.(*net.TCPAddr); ok {
+			rpc.destPort = uint32(tcpAddr.Port)
+		}
+		if tcpAddr, ok := p.Addr.(*net.TCPAddr); ok {
+			rpc.sourceIP = tcpAddr.IP
+		}
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			rpc.authenticated = &tlsInfo.State
+		}
+	}
+
+	matched := ""
+	for _, name := range e.order {
+		if e.policies[name].matches(rpc) {
+			matched = name
+			break
+		}
+	}
+
+	switch e.action {
+	case v3rbacpb.RBAC_ALLOW:
+		return matched != "", matched
+	case v3rbacpb.RBAC_DENY:
+		return matched == "", matched
+	default:
+		// LOG_ACTION and any future action are not authorization decisions;
+		// treat as allow so an unrecognized action fails open rather than
+		// locking every RPC out.
+		logger.Warningf("rbac: unsupported RBAC action %v, allowing RPC %q", e.action, method)
+		return true, matched
+	}
+}
+
+// principalFromState derives a human-readable caller identity from an
+// authenticated TLS connection state, falling back to the first URI or DNS
+// SAN, then the certificate's subject common name.
+func principalFromState(state *tls.ConnectionState) string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	cert := state.PeerCertificates[0]
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return (pkix.Name{CommonName: cert.Subject.CommonName}).CommonName
+}
+
+// EvaluateAndAudit calls Evaluate, reports the result to sink (if non-nil)
+// as a Decision, and returns an error suitable for returning directly from
+// a unary or stream server interceptor: nil if the RPC is allowed, else a
+// codes.PermissionDenied status naming the policy (or noting that no
+// policy matched) that caused the denial.
+func (e *Engine) EvaluateAndAudit(ctx context.Context, method string, sink AuditSink) error {
+	allowed, policyName := e.Evaluate(ctx, method)
+
+	principal := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		principal = p.Addr.String()
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			if id := principalFromState(&tlsInfo.State); id != "" {
+				principal = id
+			}
+		}
+	}
+	if sink != nil {
+		sink.LogDecision(Decision{
+			Allowed:    allowed,
+			Policy:     policyName,
+			FullMethod: method,
+			Principal:  principal,
+		})
+	}
+	if allowed {
+		return nil
+	}
+	if policyName == "" {
+		return status.Errorf(codes.PermissionDenied, "rbac: no policy matched the incoming RPC")
+	}
+	return status.Errorf(codes.PermissionDenied, "rbac: denied by policy %q", policyName)
+}
+
+func newPermissionMatchers(pbs []*v3rbacpb.Permission) ([]matcher, error) {
+	ms := make([]matcher, 0, len(pbs))
+	for _, pb := range pbs {
+		m, err := newPermissionMatcher(pb)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+func newPrincipalMatchers(pbs []*v3rbacpb.Principal) ([]matcher, error) {
+	ms := make([]matcher, 0, len(pbs))
+	for _, pb := range pbs {
+		m, err := newPrincipalMatcher(pb)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+func newPermissionMatcher(p *v3rbacpb.Permission) (matcher, error) {
+	switch r := p.GetRule().(type) {
+	case *v3rbacpb.Permission_AndRules:
+		ms, err := newPermissionMatchers(r.AndRules.GetRules())
+		if err != nil {
+			return nil, err
+		}
+		return func(rpc *rpcContext) bool {
+			for _, m := range ms {
+				if !m(rpc) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case *v3rbacpb.Permission_OrRules:
+		ms, err := newPermissionMatchers(r.OrRules.GetRules())
+		if err != nil {
+			return nil, err
+		}
+		return func(rpc *rpcContext) bool { return matchesAny(ms, rpc) }, nil
+	case *v3rbacpb.Permission_Any:
+		return func(*rpcContext) bool { return true }, nil
+	case *v3rbacpb.Permission_UrlPath:
+		path := r.UrlPath.GetPath()
+		return func(rpc *rpcContext) bool { return stringMatcherMatches(path, rpc.fullMethod) }, nil
+	case *v3rbacpb.Permission_DestinationPort:
+		port := r.DestinationPort
+		return func(rpc *rpcContext) bool { return rpc.destPort == port }, nil
+	case *v3rbacpb.Permission_Header:
+		h := r.Header
+		return func(rpc *rpcContext) bool { return headerMatches(h, rpc.headers) }, nil
+	case *v3rbacpb.Permission_NotRule:
+		m, err := newPermissionMatcher(r.NotRule)
+		if err != nil {
+			return nil, err
+		}
+		return func(rpc *rpcContext) bool { return !m(rpc) }, nil
+	default:
+		return nil, fmt.Errorf("unsupported permission rule %T", r)
+	}
+}
+
+func newPrincipalMatcher(p *v3rbacpb.Principal) (matcher, error) {
+	switch r := p.GetIdentifier().(type) {
+	case *v3rbacpb.Principal_AndIds:
+		ms, err := newPrincipalMatchers(r.AndIds.GetIds())
+		if err != nil {
+			return nil, err
+		}
+		return func(rpc *rpcContext) bool {
+			for _, m := range ms {
+				if !m(rpc) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case *v3rbacpb.Principal_OrIds:
+		ms, err := newPrincipalMatchers(r.OrIds.GetIds())
+		if err != nil {
+			return nil, err
+		}
+		return func(rpc *rpcContext) bool { return matchesAny(ms, rpc) }, nil
+	case *v3rbacpb.Principal_Any:
+		return func(*rpcContext) bool { return true }, nil
+	case *v3rbacpb.Principal_SourceIp:
+		_, ipNet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", r.SourceIp.GetAddressPrefix(), r.SourceIp.GetPrefixLen().GetValue()))
+		if err != nil {
+			return nil, fmt.Errorf("invalid source_ip CIDR: %v", err)
+		}
+		return func(rpc *rpcContext) bool { return rpc.sourceIP != nil && ipNet.Contains(rpc.sourceIP) }, nil
+	case *v3rbacpb.Principal_Authenticated_:
+		nameMatch := r.Authenticated.GetPrincipalName()
+		return func(rpc *rpcContext) bool {
+			if rpc.authenticated == nil {
+				return false
+			}
+			principal := principalFromState(rpc.authenticated)
+			if nameMatch == nil {
+				// An empty PrincipalName means "any authenticated
+				// principal", per the RBAC proto's documented semantics.
+				return principal != ""
+			}
+			return stringMatcherMatches(nameMatch, principal)
+		}, nil
+	case *v3rbacpb.Principal_Header:
+		h := r.Header
+		return func(rpc *rpcContext) bool { return headerMatches(h, rpc.headers) }, nil
+	case *v3rbacpb.Principal_NotId:
+		m, err := newPrincipalMatcher(r.NotId)
+		if err != nil {
+			return nil, err
+		}
+		return func(rpc *rpcContext) bool { return !m(rpc) }, nil
+	default:
+		return nil, fmt.Errorf("unsupported principal identifier %T", r)
+	}
+}
+
+// headerMatches evaluates a single Envoy HeaderMatcher against md, trying
+// each match kind that RBAC configurations commonly use. Header values set
+// more than once are joined with a comma, matching gRPC's own metadata.MD
+// convention.
+func headerMatches(h *v3routepb.HeaderMatcher, md metadata.MD) bool {
+	vals := md.Get(strings.ToLower(h.GetName()))
+	present := len(vals) > 0
+	val := strings.Join(vals, ",")
+
+	var matched bool
+	switch m := h.GetHeaderMatchSpecifier().(type) {
+	case *v3routepb.HeaderMatcher_ExactMatch:
+		matched = present && val == m.ExactMatch
+	case *v3routepb.HeaderMatcher_PrefixMatch:
+		matched = present && strings.HasPrefix(val, m.PrefixMatch)
+	case *v3routepb.HeaderMatcher_SuffixMatch:
+		matched = present && strings.HasSuffix(val, m.SuffixMatch)
+	case *v3routepb.HeaderMatcher_ContainsMatch:
+		matched = present && strings.Contains(val, m.ContainsMatch)
+	case *v3routepb.HeaderMatcher_PresentMatch:
+		matched = present == m.PresentMatch
+	default:
+		// RangeMatch and SafeRegexMatch are valid per the RBAC proto but
+		// are not yet translated here; treat as non-matching rather than
+		// panicking on an unrecognized oneof.
+		matched = false
+	}
+	if h.GetInvertMatch() {
+		return !matched
+	}
+	return matched
+}
+
+// stringMatcherMatches evaluates value against an Envoy
+// type.matcher.v3.StringMatcher, used for url_path and
+// authenticated.principal_name matching. SafeRegex matching is not
+// supported; a StringMatcher relying on it never matches.
+func stringMatcherMatches(sm *v3matcherpb.StringMatcher, value string) bool {
+	cased := func(s string) string {
+		if sm.GetIgnoreCase() {
+			return strings.ToLower(s)
+		}
+		return s
+	}
+	v := cased(value)
+	switch m := sm.GetMatchPattern().(type) {
+	case *v3matcherpb.StringMatcher_Exact:
+		return v == cased(m.Exact)
+	case *v3matcherpb.StringMatcher_Prefix:
+		return strings.HasPrefix(v, cased(m.Prefix))
+	case *v3matcherpb.StringMatcher_Suffix:
+		return strings.HasSuffix(v, cased(m.Suffix))
+	case *v3matcherpb.StringMatcher_Contains:
+		return strings.Contains(v, cased(m.Contains))
+	default:
+		return false
+	}
+}
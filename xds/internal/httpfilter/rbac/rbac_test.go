@@ -0,0 +1,210 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rbac
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3rbacpb "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	v3matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
+
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/metadata"
+	"github.com/qiaohao9/grpc/peer"
+	"github.com/qiaohao9/grpc/status"
+)
+
+// fakeSink records every Decision it is given, for assertions.
+type fakeSink struct {
+	decisions []Decision
+}
+
+func (f *fakeSink) LogDecision(d Decision) { f.decisions = append(f.decisions, d) }
+
+func ctxWithPeer(addr net.Addr) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+}
+
+func TestEvaluate_NilEngine(t *testing.T) {
+	var e *Engine
+	allowed, policy := e.Evaluate(context.Background(), "/grpc.testing.TestService/EmptyCall")
+	if !allowed || policy != "" {
+		t.Fatalf("nil Engine.Evaluate() = %v, %q, want true, \"\"", allowed, policy)
+	}
+}
+
+func TestEvaluate_AllowActionEmptyPolicies(t *testing.T) {
+	// A present RBAC filter with no policies and action ALLOW must deny
+	// every RPC, since no policy can ever match.
+	e, err := New(&v3rbacpb.RBAC{Action: v3rbacpb.RBAC_ALLOW})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if allowed, _ := e.Evaluate(context.Background(), "/grpc.testing.TestService/EmptyCall"); allowed {
+		t.Fatalf("Evaluate() = true, want false for an ALLOW engine with no policies")
+	}
+}
+
+func TestEvaluate_DenyActionEmptyPolicies(t *testing.T) {
+	// A DENY engine with no policies never matches, so it allows everything.
+	e, err := New(&v3rbacpb.RBAC{Action: v3rbacpb.RBAC_DENY})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if allowed, _ := e.Evaluate(context.Background(), "/grpc.testing.TestService/EmptyCall"); !allowed {
+		t.Fatalf("Evaluate() = false, want true for a DENY engine with no policies")
+	}
+}
+
+func TestEvaluate_AllowActionURLPathMatch(t *testing.T) {
+	e, err := New(&v3rbacpb.RBAC{
+		Action: v3rbacpb.RBAC_ALLOW,
+		Policies: map[string]*v3rbacpb.Policy{
+			"allow-empty-call": {
+				Permissions: []*v3rbacpb.Permission{{
+					Rule: &v3rbacpb.Permission_UrlPath{
+						UrlPath: &v3matcherpb.PathMatcher{
+							Rule: &v3matcherpb.PathMatcher_Path{
+								Path: &v3matcherpb.StringMatcher{
+									MatchPattern: &v3matcherpb.StringMatcher_Exact{Exact: "/grpc.testing.TestService/EmptyCall"},
+								},
+							},
+						},
+					},
+				}},
+				Principals: []*v3rbacpb.Principal{{Identifier: &v3rbacpb.Principal_Any{Any: true}}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	tests := []struct {
+		method     string
+		wantAllow  bool
+		wantPolicy string
+	}{
+		{method: "/grpc.testing.TestService/EmptyCall", wantAllow: true, wantPolicy: "allow-empty-call"},
+		{method: "/grpc.testing.TestService/UnaryCall", wantAllow: false, wantPolicy: ""},
+	}
+	for _, test := range tests {
+		allowed, policy := e.Evaluate(context.Background(), test.method)
+		if allowed != test.wantAllow || policy != test.wantPolicy {
+			t.Errorf("Evaluate(%q) = %v, %q, want %v, %q", test.method, allowed, policy, test.wantAllow, test.wantPolicy)
+		}
+	}
+}
+
+func TestEvaluate_SourceIPMatch(t *testing.T) {
+	e, err := New(&v3rbacpb.RBAC{
+		Action: v3rbacpb.RBAC_DENY,
+		Policies: map[string]*v3rbacpb.Policy{
+			"deny-internal-subnet": {
+				Permissions: []*v3rbacpb.Permission{{Rule: &v3rbacpb.Permission_Any{Any: true}}},
+				Principals: []*v3rbacpb.Principal{{
+					Identifier: &v3rbacpb.Principal_SourceIp{
+						SourceIp: &v3corepb.CidrRange{
+							AddressPrefix: "10.0.0.0",
+							PrefixLen:     &wrapperspb.UInt32Value{Value: 8},
+						},
+					},
+				}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	blocked := ctxWithPeer(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234})
+	if allowed, policy := e.Evaluate(blocked, "/grpc.testing.TestService/EmptyCall"); allowed || policy != "deny-internal-subnet" {
+		t.Errorf("Evaluate() for 10.1.2.3 = %v, %q, want false, \"deny-internal-subnet\"", allowed, policy)
+	}
+
+	allowedCtx := ctxWithPeer(&net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1234})
+	if allowed, _ := e.Evaluate(allowedCtx, "/grpc.testing.TestService/EmptyCall"); !allowed {
+		t.Errorf("Evaluate() for 192.168.1.1 = false, want true")
+	}
+}
+
+func TestEvaluateAndAudit(t *testing.T) {
+	e, err := New(&v3rbacpb.RBAC{Action: v3rbacpb.RBAC_ALLOW})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	sink := &fakeSink{}
+	err = e.EvaluateAndAudit(ctxWithPeer(&net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1}), "/grpc.testing.TestService/EmptyCall", sink)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("EvaluateAndAudit() = %v, want codes.PermissionDenied", err)
+	}
+	if len(sink.decisions) != 1 {
+		t.Fatalf("sink recorded %d decisions, want 1", len(sink.decisions))
+	}
+	if d := sink.decisions[0]; d.Allowed || d.FullMethod != "/grpc.testing.TestService/EmptyCall" {
+		t.Errorf("sink recorded %+v, want Allowed=false, FullMethod=/grpc.testing.TestService/EmptyCall", d)
+	}
+}
+
+func TestHeaderMatches(t *testing.T) {
+	md := metadata.Pairs("x-custom", "foo")
+	tests := []struct {
+		name string
+		hm   *v3routepb.HeaderMatcher
+		want bool
+	}{
+		{
+			name: "exact-match",
+			hm:   &v3routepb.HeaderMatcher{Name: "x-custom", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_ExactMatch{ExactMatch: "foo"}},
+			want: true,
+		},
+		{
+			name: "exact-mismatch",
+			hm:   &v3routepb.HeaderMatcher{Name: "x-custom", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_ExactMatch{ExactMatch: "bar"}},
+			want: false,
+		},
+		{
+			name: "present",
+			hm:   &v3routepb.HeaderMatcher{Name: "x-custom", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_PresentMatch{PresentMatch: true}},
+			want: true,
+		},
+		{
+			name: "absent-header-present-false-matches",
+			hm:   &v3routepb.HeaderMatcher{Name: "x-missing", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_PresentMatch{PresentMatch: false}},
+			want: true,
+		},
+		{
+			name: "invert-exact-match",
+			hm:   &v3routepb.HeaderMatcher{Name: "x-custom", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_ExactMatch{ExactMatch: "foo"}, InvertMatch: true},
+			want: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := headerMatches(test.hm, md); got != test.want {
+				t.Errorf("headerMatches() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
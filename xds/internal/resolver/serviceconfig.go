@@ -0,0 +1,227 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	xxhash "github.com/cespare/xxhash/v2"
+	"github.com/qiaohao9/grpc/internal/grpcrand"
+	iresolver "github.com/qiaohao9/grpc/internal/resolver"
+	"github.com/qiaohao9/grpc/metadata"
+	"github.com/qiaohao9/grpc/resolver"
+	xdsinternal "github.com/qiaohao9/grpc/xds/internal"
+	"github.com/qiaohao9/grpc/xds/internal/xdsclient"
+)
+
+// clusterInfo tracks how many routes in the current service config
+// reference a cluster, so the resolver knows when it is safe to stop
+// watching a cluster that is no longer referenced by anything.
+type clusterInfo struct {
+	refCount int
+}
+
+// xdsResolver watches an xDS RouteConfiguration and turns it into a gRPC
+// service config, picking a configSelector that routes each RPC to a
+// cluster and stamps a request hash (for ring_hash clusters) on its
+// context.
+type xdsResolver struct {
+	cc resolver.ClientConn
+
+	mu             sync.Mutex
+	activeClusters map[string]*clusterInfo
+}
+
+// pruneActiveClusters removes activeClusters entries that are no longer
+// referenced by any route (refCount has dropped to zero), so the resolver
+// stops tracking clusters nothing routes to anymore.
+func (r *xdsResolver) pruneActiveClusters() {
+	for cluster, ci := range r.activeClusters {
+		if ci.refCount == 0 {
+			delete(r.activeClusters, cluster)
+		}
+	}
+}
+
+// configSelector picks a cluster (and computes a request hash, for
+// ring_hash-balanced clusters) for each outgoing RPC based on the route
+// table built from the most recent RouteConfiguration update.
+type configSelector struct {
+	r *xdsResolver
+}
+
+// generateHash computes a request hash by applying hashPolicies in order,
+// combining the hash produced by each one (via XOR, matching Envoy's
+// combine-by-XOR semantics for a single request) into the running total.
+// Application stops as soon as a Terminal policy produces a hash.
+//
+// The second return value collects, by cookie name, any cookie values
+// synthesized along the way because their configured cookie was absent
+// from the request - the caller is responsible for attaching these to
+// the RPC's outgoing metadata (see SelectConfig), since generateHash
+// itself only computes the hash and has no access to the stream that
+// will carry it.
+func (cs *configSelector) generateHash(rpcInfo iresolver.RPCInfo, hashPolicies []*xdsclient.HashPolicy) (uint64, map[string]string) {
+	var requestHash uint64
+	var setCookies map[string]string
+	for _, policy := range hashPolicies {
+		var (
+			policyHash    uint64
+			generatedHash bool
+			cookie        string
+		)
+		switch policy.HashPolicyType {
+		case xdsclient.HashPolicyTypeHeader:
+			policyHash, generatedHash = cs.generateHashHeader(rpcInfo, policy)
+		case xdsclient.HashPolicyTypeChannelID:
+			policyHash, generatedHash = cs.generateHashChannelID()
+		case xdsclient.HashPolicyTypeCookie:
+			policyHash, generatedHash, cookie = cs.generateHashCookie(rpcInfo, policy)
+		case xdsclient.HashPolicyTypeQueryParameter:
+			policyHash, generatedHash = cs.generateHashQueryParameter(rpcInfo, policy)
+		}
+		if !generatedHash {
+			continue
+		}
+		if cookie != "" {
+			if setCookies == nil {
+				setCookies = make(map[string]string)
+			}
+			setCookies[policy.CookieName] = cookie
+		}
+		requestHash ^= policyHash
+		if policy.Terminal {
+			break
+		}
+	}
+	return requestHash, setCookies
+}
+
+// SelectConfig is the entry point the RPC-issuing path calls to hash
+// rpcInfo against hashPolicies and obtain the context that RPC should
+// actually be sent with. The returned context always carries the computed
+// request hash via xdsinternal.SetRequestHash, the same way the ring_hash
+// picker retrieves it with xdsinternal.GetRequestHash, so the caller only
+// has to swap in the returned context rather than separately threading the
+// returned hash anywhere itself. It additionally carries an outgoing
+// "cookie" header when generateHash synthesized one or more cookies, so
+// that the caller's next RPC on the same channel presents the same cookie
+// and hashes to the same ring_hash entry.
+func (cs *configSelector) SelectConfig(rpcInfo iresolver.RPCInfo, hashPolicies []*xdsclient.HashPolicy) (uint64, context.Context) {
+	requestHash, setCookies := cs.generateHash(rpcInfo, hashPolicies)
+	ctx := xdsinternal.SetRequestHash(rpcInfo.Context, requestHash)
+	if len(setCookies) == 0 {
+		return requestHash, ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	for name, value := range setCookies {
+		md.Append("cookie", fmt.Sprintf("%s=%s", name, value))
+	}
+	return requestHash, metadata.NewOutgoingContext(ctx, md)
+}
+
+// incomingHeader returns the first value of header name on rpcInfo, with
+// ":path" additionally falling back to rpcInfo.Method when the metadata
+// doesn't carry it explicitly (as in the unit tests, which set up metadata
+// directly rather than going through a real RPC).
+func incomingHeader(rpcInfo iresolver.RPCInfo, name string) (string, bool) {
+	if md, ok := metadata.FromIncomingContext(rpcInfo.Context); ok {
+		if v := md.Get(name); len(v) > 0 {
+			return v[0], true
+		}
+	}
+	if name == ":path" && rpcInfo.Method != "" {
+		return rpcInfo.Method, true
+	}
+	return "", false
+}
+
+func (cs *configSelector) generateHashHeader(rpcInfo iresolver.RPCInfo, policy *xdsclient.HashPolicy) (uint64, bool) {
+	value, ok := incomingHeader(rpcInfo, policy.HeaderName)
+	if !ok {
+		return 0, false
+	}
+	if policy.Regex != nil {
+		value = policy.Regex.ReplaceAllString(value, policy.RegexSubstitution)
+	}
+	return xxhash.Sum64String(value), true
+}
+
+// generateHashChannelID hashes something that uniquely identifies this
+// ClientConn, so that all RPCs on the same channel land on the same
+// ring_hash entry.
+func (cs *configSelector) generateHashChannelID() (uint64, bool) {
+	return xxhash.Sum64String(fmt.Sprintf("%p", &cs.r.cc)), true
+}
+
+// generateHashCookie hashes the named cookie's value out of the "cookie"
+// header (an RFC 6265 cookie-pair list). If the cookie isn't present and
+// the policy has a TTL configured, a random value is synthesized, hashed,
+// and returned as the cookie to set, pinning subsequent calls to the same
+// hash once the caller attaches it to the outgoing RPC.
+func (cs *configSelector) generateHashCookie(rpcInfo iresolver.RPCInfo, policy *xdsclient.HashPolicy) (uint64, bool, string) {
+	if lines, ok := metadata.FromIncomingContext(rpcInfo.Context); ok {
+		for _, line := range lines.Get("cookie") {
+			for _, pair := range strings.Split(line, ";") {
+				parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(parts) == 2 && parts[0] == policy.CookieName {
+					return xxhash.Sum64String(parts[1]), true, ""
+				}
+			}
+		}
+	}
+	if policy.CookieTTL == 0 {
+		return 0, false, ""
+	}
+	value := newCookieValue()
+	return xxhash.Sum64String(value), true, value
+}
+
+// generateHashQueryParameter hashes the first occurrence of the named query
+// parameter in the RPC's ":path" pseudo-header.
+func (cs *configSelector) generateHashQueryParameter(rpcInfo iresolver.RPCInfo, policy *xdsclient.HashPolicy) (uint64, bool) {
+	path, ok := incomingHeader(rpcInfo, ":path")
+	if !ok {
+		return 0, false
+	}
+	i := strings.Index(path, "?")
+	if i < 0 {
+		return 0, false
+	}
+	values, err := url.ParseQuery(path[i+1:])
+	if err != nil {
+		return 0, false
+	}
+	v, ok := values[policy.QueryParameterName]
+	if !ok || len(v) == 0 {
+		return 0, false
+	}
+	return xxhash.Sum64String(v[0]), true
+}
+
+// newCookieValue synthesizes a value for a hash-policy cookie that wasn't
+// present on the incoming request.
+func newCookieValue() string {
+	return fmt.Sprintf("%x", grpcrand.Int63())
+}
@@ -22,12 +22,15 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	xxhash "github.com/cespare/xxhash/v2"
 	"github.com/google/go-cmp/cmp"
 	iresolver "github.com/qiaohao9/grpc/internal/resolver"
 	"github.com/qiaohao9/grpc/metadata"
+	xdsinternal "github.com/qiaohao9/grpc/xds/internal"
 	_ "github.com/qiaohao9/grpc/xds/internal/balancer/cdsbalancer" // To parse LB config
 	"github.com/qiaohao9/grpc/xds/internal/xdsclient"
 )
@@ -108,10 +111,111 @@ func (s) TestGenerateRequestHash(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			requestHashGot := cs.generateHash(test.rpcInfo, test.hashPolicies)
+			requestHashGot, _ := cs.generateHash(test.rpcInfo, test.hashPolicies)
 			if requestHashGot != test.requestHashWant {
 				t.Fatalf("requestHashGot = %v, requestHashWant = %v", requestHashGot, test.requestHashWant)
 			}
 		})
 	}
 }
+
+// TestGenerateRequestHashCookie tests generating request hashes for hash
+// policies that specify to hash a named cookie's value, both when present
+// on the request and when absent but a TTL requires one to be synthesized.
+func (s) TestGenerateRequestHashCookie(t *testing.T) {
+	cs := &configSelector{
+		r: &xdsResolver{
+			cc: &testClientConn{},
+		},
+	}
+	policy := &xdsclient.HashPolicy{
+		HashPolicyType: xdsclient.HashPolicyTypeCookie,
+		CookieName:     "my-cookie",
+	}
+	rpcInfo := iresolver.RPCInfo{
+		Context: metadata.NewIncomingContext(context.Background(), metadata.Pairs("cookie", "other=1; my-cookie=some-value")),
+		Method:  "/some-method",
+	}
+	requestHashWant := xxhash.Sum64String("some-value")
+	if got, _ := cs.generateHash(rpcInfo, []*xdsclient.HashPolicy{policy}); got != requestHashWant {
+		t.Fatalf("requestHashGot = %v, requestHashWant = %v", got, requestHashWant)
+	}
+
+	// With no TTL configured, a missing cookie produces no hash at all.
+	missing := iresolver.RPCInfo{Method: "/some-method"}
+	if got, _ := cs.generateHash(missing, []*xdsclient.HashPolicy{policy}); got != 0 {
+		t.Fatalf("requestHashGot = %v, want 0 for a missing cookie with no TTL", got)
+	}
+
+	// With a TTL configured, a missing cookie synthesizes a value and is
+	// reported back via the second return value.
+	policy.CookieTTL = time.Minute
+	got, setCookies := cs.generateHash(missing, []*xdsclient.HashPolicy{policy})
+	if got == 0 {
+		t.Fatalf("requestHashGot = 0, want a synthesized hash for a missing cookie with a TTL set")
+	}
+	if _, ok := setCookies[policy.CookieName]; !ok {
+		t.Fatalf("setCookies[%q] not set after synthesizing a cookie value", policy.CookieName)
+	}
+
+	// SelectConfig attaches the synthesized cookie to the outgoing RPC
+	// context, so the caller's next RPC on this channel presents it.
+	wantHash, ctx := cs.SelectConfig(missing, []*xdsclient.HashPolicy{policy})
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		t.Fatalf("SelectConfig did not attach outgoing metadata for a synthesized cookie")
+	}
+	found := false
+	for _, line := range md.Get("cookie") {
+		if strings.HasPrefix(line, policy.CookieName+"=") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("outgoing \"cookie\" metadata %v does not contain a value for %q", md.Get("cookie"), policy.CookieName)
+	}
+
+	// The returned context must also carry the request hash the way the
+	// ring_hash picker actually retrieves it, via xdsinternal.GetRequestHash
+	// - not just as SelectConfig's bare first return value - since the
+	// picker never sees that return value, only whatever context the RPC is
+	// issued with.
+	gotHash, ok := xdsinternal.GetRequestHash(ctx)
+	if !ok {
+		t.Fatalf("xdsinternal.GetRequestHash(ctx) found no hash in the context SelectConfig returned")
+	}
+	if gotHash != wantHash {
+		t.Fatalf("xdsinternal.GetRequestHash(ctx) = %v, want %v (SelectConfig's returned hash)", gotHash, wantHash)
+	}
+}
+
+// TestGenerateRequestHashQueryParameter tests generating request hashes for
+// hash policies that specify to hash a named query parameter's value.
+func (s) TestGenerateRequestHashQueryParameter(t *testing.T) {
+	cs := &configSelector{
+		r: &xdsResolver{
+			cc: &testClientConn{},
+		},
+	}
+	policy := &xdsclient.HashPolicy{
+		HashPolicyType:     xdsclient.HashPolicyTypeQueryParameter,
+		QueryParameterName: "shard",
+	}
+	rpcInfo := iresolver.RPCInfo{
+		Context: metadata.NewIncomingContext(context.Background(), metadata.Pairs(":path", "/some-method?shard=us-east%2F1&other=x")),
+		Method:  "/some-method",
+	}
+	requestHashWant := xxhash.Sum64String("us-east/1")
+	if got, _ := cs.generateHash(rpcInfo, []*xdsclient.HashPolicy{policy}); got != requestHashWant {
+		t.Fatalf("requestHashGot = %v, requestHashWant = %v", got, requestHashWant)
+	}
+
+	// A path with no matching query parameter produces no hash.
+	noMatch := iresolver.RPCInfo{
+		Context: metadata.NewIncomingContext(context.Background(), metadata.Pairs(":path", "/some-method?other=x")),
+		Method:  "/some-method",
+	}
+	if got, _ := cs.generateHash(noMatch, []*xdsclient.HashPolicy{policy}); got != 0 {
+		t.Fatalf("requestHashGot = %v, want 0 when the query parameter is absent", got)
+	}
+}
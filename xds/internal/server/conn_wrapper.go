@@ -0,0 +1,163 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/qiaohao9/grpc/xds/internal/httpfilter"
+	"github.com/qiaohao9/grpc/xds/internal/xdsclient"
+)
+
+// connWrapper wraps an accepted net.Conn together with the filter chain
+// that matched it, the ServerInterceptors built from that filter chain's
+// top-level (connection-scoped) HTTP filters, and the route table derived
+// from it, so that the server's unary/stream interceptors can resolve the
+// right interceptors for each RPC received on this connection via
+// InterceptorsForRoute.
+//
+// Built once per accepted connection in listenerWrapper.Accept: the filter
+// chain match, and the connection-level interceptors built from it, don't
+// change for the life of the connection. A route's own interceptors can
+// still differ per RPC, though, when that route carries a
+// FilterConfigOverride - see InterceptorsForRoute.
+type connWrapper struct {
+	net.Conn
+
+	filterChain  *xdsclient.FilterChain
+	parent       *listenerWrapper
+	virtualHosts []xdsclient.VirtualHostWithInterceptors
+
+	interceptors []httpfilter.ServerInterceptor
+}
+
+// Interceptors returns the ServerInterceptors built from this connection's
+// matched filter chain, in the order its HTTP filters were configured.
+func (c *connWrapper) Interceptors() []httpfilter.ServerInterceptor {
+	return c.interceptors
+}
+
+// Close closes the underlying net.Conn and stops c's parent listenerWrapper
+// from tracking it, so that a later ForceCloseConns call (e.g. once a
+// drain's grace period elapses) does not try to close it a second time.
+func (c *connWrapper) Close() error {
+	if c.parent != nil {
+		c.parent.removeConn(c)
+	}
+	return c.Conn.Close()
+}
+
+// InterceptorsForRoute returns the ServerInterceptors to run for an RPC to
+// fullMethod (e.g. "/grpc.testing.TestService/EmptyCall"): the matching
+// route's own interceptors, if fullMethod matches a route in
+// c.virtualHosts and that route carries any (xdsclient bakes a route's
+// FilterConfigOverride, if any, into its Interceptors when it builds
+// c.virtualHosts, taking precedence over the connection-level HTTP filter
+// config there), or else the connection-level interceptors built from the
+// filter chain's top-level HTTP filters.
+func (c *connWrapper) InterceptorsForRoute(fullMethod string) []httpfilter.ServerInterceptor {
+	if r, ok := matchRoute(c.virtualHosts, fullMethod); ok && len(r.Interceptors) > 0 {
+		return r.Interceptors
+	}
+	return c.interceptors
+}
+
+// RouteInterceptors is implemented by the net.Conn value that
+// listenerWrapper.Accept returns, and is what the public xds package's
+// grpc.ConnContext hook type-asserts c against to resolve, once an RPC's
+// method name is known, the interceptors that apply to it.
+type RouteInterceptors interface {
+	InterceptorsForRoute(fullMethod string) []httpfilter.ServerInterceptor
+}
+
+// InterceptorsFromConn returns c as a RouteInterceptors, or nil if c was
+// not accepted by a listenerWrapper. The public xds package calls this,
+// from the grpc.ConnContext hook it installs, to later recover - per RPC,
+// once the method name is known - the HTTP filter interceptors to run for
+// RPCs received on c.
+func InterceptorsFromConn(c net.Conn) RouteInterceptors {
+	cw, ok := c.(*connWrapper)
+	if !ok {
+		return nil
+	}
+	return cw
+}
+
+// matchRoute returns the first route, searched across every virtual host
+// in vhs in order, whose path matcher matches fullMethod. A server
+// listener's filter chain isn't scoped to a client-chosen authority the
+// way a virtual host's Domains are on the client/resolver side, so every
+// virtual host is searched rather than first narrowing to one by domain.
+func matchRoute(vhs []xdsclient.VirtualHostWithInterceptors, fullMethod string) (r xdsclient.RouteWithInterceptors, ok bool) {
+	for _, vh := range vhs {
+		for _, route := range vh.Routes {
+			if routeMatchesMethod(route, fullMethod) {
+				return route, true
+			}
+		}
+	}
+	return xdsclient.RouteWithInterceptors{}, false
+}
+
+// routeMatchesMethod reports whether r's path matcher matches fullMethod,
+// trying, in order, an exact path match, a prefix match, and a regex
+// match, per whichever of those r's RouteMatch configured.
+func routeMatchesMethod(r xdsclient.RouteWithInterceptors, fullMethod string) bool {
+	switch {
+	case r.Path != nil:
+		return *r.Path == fullMethod
+	case r.Prefix != nil:
+		return strings.HasPrefix(fullMethod, *r.Prefix)
+	case r.Regex != nil:
+		return r.Regex.MatchString(fullMethod)
+	default:
+		return false
+	}
+}
+
+// buildInterceptors builds one httpfilter.ServerInterceptor per HTTP
+// filter configured on fc, via the httpfilter registry, skipping (with a
+// warning) any filter whose type URL has no registered implementation or
+// that fails to build - an RPC on this connection then proceeds as though
+// that filter were absent rather than the connection being refused.
+func buildInterceptors(logger interface {
+	Warningf(format string, args ...interface{})
+}, fc *xdsclient.FilterChain) []httpfilter.ServerInterceptor {
+	var interceptors []httpfilter.ServerInterceptor
+	for _, hf := range fc.HTTPFilters {
+		f := httpfilter.Get(hf.TypeURL)
+		if f == nil {
+			logger.Warningf("no httpfilter registered for type URL %q, skipping", hf.TypeURL)
+			continue
+		}
+		sib, ok := f.(httpfilter.ServerInterceptorBuilder)
+		if !ok {
+			logger.Warningf("httpfilter %q does not support server-side interception, skipping", hf.TypeURL)
+			continue
+		}
+		si, err := sib.BuildServerInterceptor(hf.Config)
+		if err != nil {
+			logger.Warningf("failed to build server interceptor for HTTP filter %q: %v, skipping", hf.Name, err)
+			continue
+		}
+		interceptors = append(interceptors, si)
+	}
+	return interceptors
+}
@@ -0,0 +1,87 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qiaohao9/grpc/xds/internal/httpfilter"
+	"github.com/qiaohao9/grpc/xds/internal/xdsclient"
+)
+
+// stubInterceptor is a trivial httpfilter.ServerInterceptor identified by a
+// name, so tests can assert on which one(s) InterceptorsForRoute selected.
+type stubInterceptor struct{ name string }
+
+func (stubInterceptor) AllowRPC(context.Context) error { return nil }
+
+func strPtr(s string) *string { return &s }
+
+// TestInterceptorsForRouteUsesConnLevelWithNoRouteMatch verifies that an
+// RPC whose method matches no route in the connection's virtual hosts (for
+// example, because RDS has not yet delivered a route table) falls back to
+// the connection-level interceptors built from the filter chain's
+// top-level HTTP filters.
+func TestInterceptorsForRouteUsesConnLevelWithNoRouteMatch(t *testing.T) {
+	connLevel := []httpfilter.ServerInterceptor{stubInterceptor{name: "conn-level"}}
+	c := &connWrapper{interceptors: connLevel}
+
+	got := c.InterceptorsForRoute("/grpc.testing.TestService/EmptyCall")
+	if len(got) != 1 || got[0] != connLevel[0] {
+		t.Errorf("InterceptorsForRoute() = %v, want the connection-level interceptors %v", got, connLevel)
+	}
+}
+
+// TestInterceptorsForRoutePrefersRouteOverride verifies that a route whose
+// own Interceptors are non-empty - standing in for one with a
+// FilterConfigOverride - takes precedence over the connection-level
+// interceptors for an RPC matching that route, while other RPCs on the
+// same connection still see the connection-level ones.
+func TestInterceptorsForRoutePrefersRouteOverride(t *testing.T) {
+	connLevel := []httpfilter.ServerInterceptor{stubInterceptor{name: "conn-level"}}
+	routeOverride := []httpfilter.ServerInterceptor{stubInterceptor{name: "route-override"}}
+	c := &connWrapper{
+		interceptors: connLevel,
+		virtualHosts: []xdsclient.VirtualHostWithInterceptors{
+			{
+				Domains: []string{"*"},
+				Routes: []xdsclient.RouteWithInterceptors{
+					{
+						Path:         strPtr("/grpc.testing.TestService/EmptyCall"),
+						Interceptors: routeOverride,
+					},
+					{
+						Prefix: strPtr("/"),
+					},
+				},
+			},
+		},
+	}
+
+	got := c.InterceptorsForRoute("/grpc.testing.TestService/EmptyCall")
+	if len(got) != 1 || got[0] != routeOverride[0] {
+		t.Errorf("InterceptorsForRoute(EmptyCall) = %v, want the route override %v", got, routeOverride)
+	}
+
+	got = c.InterceptorsForRoute("/grpc.testing.TestService/UnaryCall")
+	if len(got) != 1 || got[0] != connLevel[0] {
+		t.Errorf("InterceptorsForRoute(UnaryCall) = %v, want the connection-level interceptors %v (its matched route has no override)", got, connLevel)
+	}
+}
@@ -21,6 +21,7 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -34,6 +35,7 @@ import (
 	internalbackoff "github.com/qiaohao9/grpc/internal/backoff"
 	internalgrpclog "github.com/qiaohao9/grpc/internal/grpclog"
 	"github.com/qiaohao9/grpc/internal/grpcsync"
+	"github.com/qiaohao9/grpc/xds/internal/server/orca"
 	"github.com/qiaohao9/grpc/xds/internal/xdsclient"
 	"github.com/qiaohao9/grpc/xds/internal/xdsclient/bootstrap"
 )
@@ -51,6 +53,28 @@ var (
 	backoffFunc = bs.Backoff
 )
 
+// defaultDrainGracePeriod is the grace period given to long-lived RPCs on a
+// draining connection to finish, per gRFC A36, used when
+// ListenerWrapperParams.DrainGracePeriod is left unset.
+const defaultDrainGracePeriod = 10 * time.Minute
+
+// defaultORCAReportingInterval is the out-of-band ORCA reporting interval
+// used when ORCAConfig.Enable is set but ORCAConfig.ReportingInterval is
+// left unset.
+const defaultORCAReportingInterval = time.Second
+
+// ORCAConfig configures Open Request Cost Aggregation load reporting for
+// connections accepted by a listenerWrapper.
+type ORCAConfig struct {
+	// Enable turns on ORCA load reporting: per-RPC trailers and the
+	// out-of-band reporting service.
+	Enable bool
+	// ReportingInterval is how often the out-of-band reporter publishes a
+	// new load-report snapshot. Defaults to one second if zero and Enable
+	// is set.
+	ReportingInterval time.Duration
+}
+
 // ServingMode indicates the current mode of operation of the server.
 //
 // This API exactly mirrors the one in the public xds package. We have to
@@ -88,11 +112,15 @@ func (s ServingMode) String() string {
 type ServingModeCallback func(addr net.Addr, mode ServingMode, err error)
 
 // DrainCallback is the callback that an xDS-enabled server registers to get
-// notified about updates to the Listener configuration. The server is expected
-// to gracefully shutdown existing connections, thereby forcing clients to
-// reconnect and have the new configuration applied to the newly created
-// connections.
-type DrainCallback func(addr net.Addr)
+// notified about updates to the Listener configuration. The server is
+// expected to immediately send GOAWAY on existing connections; it need not
+// hard-close them itself once drainCtx is Done, since the listenerWrapper
+// that invokes this callback already does so for every connection it
+// accepted, via its own drainCtx-expiry goroutine (see onDrain/
+// ForceCloseConns). Overlapping Listener updates received while a drain is
+// already in progress share the same drainCtx rather than restarting the
+// grace period.
+type DrainCallback func(addr net.Addr, drainCtx context.Context)
 
 func prefixLogger(p *listenerWrapper) *internalgrpclog.PrefixLogger {
 	return internalgrpclog.NewPrefixLogger(logger, fmt.Sprintf("[xds-server-listener %p] ", p))
@@ -122,6 +150,32 @@ type ListenerWrapperParams struct {
 	// DrainCallback is the callback to invoke when the Listener gets a LDS
 	// update.
 	DrainCallback DrainCallback
+	// DrainGracePeriod is the amount of time, per gRFC A36, that old
+	// connections are given to finish long-lived RPCs after a Listener
+	// update before the server hard-closes them. If zero, it defaults to 10
+	// minutes.
+	DrainGracePeriod time.Duration
+	// ORCAConfig configures Open Request Cost Aggregation load reporting
+	// for connections accepted by this listener. Reporting is disabled
+	// unless ORCAConfig.Enable is set.
+	ORCAConfig ORCAConfig
+}
+
+// newORCAReporting builds the out-of-band Service/OOBReporter described by
+// cfg, or two nil values if cfg.Enable is false. Per-RPC reporting (see
+// orca.UnaryServerInterceptor/StreamServerInterceptor) carries no
+// listener-specific state, so it is installed directly by xds.NewGRPCServer
+// rather than threaded through here.
+func newORCAReporting(cfg ORCAConfig) (*orca.Service, *orca.OOBReporter) {
+	if !cfg.Enable {
+		return nil, nil
+	}
+	interval := cfg.ReportingInterval
+	if interval == 0 {
+		interval = defaultORCAReportingInterval
+	}
+	reporter := orca.NewOOBReporter(orca.NewMetricRecorder(), interval)
+	return orca.NewService(reporter), reporter
 }
 
 // NewListenerWrapper creates a new listenerWrapper with params. It returns a
@@ -130,6 +184,11 @@ type ListenerWrapperParams struct {
 //
 // Only TCP listeners are supported.
 func NewListenerWrapper(params ListenerWrapperParams) (net.Listener, <-chan struct{}) {
+	drainGracePeriod := params.DrainGracePeriod
+	if drainGracePeriod == 0 {
+		drainGracePeriod = defaultDrainGracePeriod
+	}
+	orcaService, oobReporter := newORCAReporting(params.ORCAConfig)
 	lw := &listenerWrapper{
 		Listener:          params.Listener,
 		name:              params.ListenerResourceName,
@@ -137,12 +196,18 @@ func NewListenerWrapper(params ListenerWrapperParams) (net.Listener, <-chan stru
 		xdsC:              params.XDSClient,
 		modeCallback:      params.ModeCallback,
 		drainCallback:     params.DrainCallback,
+		drainGracePeriod:  drainGracePeriod,
 		isUnspecifiedAddr: params.Listener.Addr().(*net.TCPAddr).IP.IsUnspecified(),
+		orcaService:       orcaService,
+		oobReporter:       oobReporter,
 
 		closed:      grpcsync.NewEvent(),
 		goodUpdate:  grpcsync.NewEvent(),
 		ldsUpdateCh: make(chan ldsUpdateWithError, 1),
 		rdsUpdateCh: make(chan rdsHandlerUpdate, 1),
+
+		routeConfigCache: make(map[*xdsclient.FilterChain]cachedRoutes),
+		conns:            make(map[*connWrapper]struct{}),
 	}
 	lw.logger = prefixLogger(lw)
 
@@ -182,6 +247,27 @@ type listenerWrapper struct {
 	modeCallback  ServingModeCallback
 	drainCallback DrainCallback
 
+	// drainGracePeriod bounds how long a drain's context stays un-Done once
+	// started; see onDrain.
+	drainGracePeriod time.Duration
+	// drainMu guards drainCancel.
+	drainMu sync.Mutex
+	// drainCancel cancels the context passed to the most recent invocation
+	// of drainCallback. It is non-nil only while that drain's grace period
+	// is still running, which lets onDrain coalesce LDS updates that arrive
+	// while a drain is already in progress instead of stacking a new timer
+	// per update.
+	drainCancel context.CancelFunc
+
+	// connsMu guards conns.
+	connsMu sync.Mutex
+	// conns is the set of connWrappers currently accepted and not yet
+	// closed, so that ForceCloseConns can hard-close every connection still
+	// open past a drain's grace period - drainCallback only runs once per
+	// Listener update and, on its own, has no way to act again once
+	// drainCtx expires.
+	conns map[*connWrapper]struct{}
+
 	// Set to true if the listener is bound to the IP_ANY address (which is
 	// "0.0.0.0" for IPv4 and "::" for IPv6).
 	isUnspecifiedAddr bool
@@ -189,6 +275,13 @@ type listenerWrapper struct {
 	// Listener resource received from the control plane.
 	addr, port string
 
+	// orcaService, if non-nil, implements the ORCA out-of-band reporting
+	// streaming RPC and must be registered on the grpc.Server that serves
+	// this listener.
+	orcaService *orca.Service
+	// oobReporter drives orcaService and is stopped in Close.
+	oobReporter *orca.OOBReporter
+
 	// This is used to notify that a good update has been received and that
 	// Serve() can be invoked on the underlying gRPC server. Using an event
 	// instead of a vanilla channel simplifies the update handler as it need not
@@ -222,6 +315,27 @@ type listenerWrapper struct {
 	ldsUpdateCh chan ldsUpdateWithError
 	// rdsUpdateCh is a channel for XDSClient RDS updates.
 	rdsUpdateCh chan rdsHandlerUpdate
+
+	// routeConfigGen is bumped by switchMode every time filterChains or
+	// rdsUpdates is replaced by a new LDS/RDS update, invalidating any
+	// entries in routeConfigCache computed for an older generation.
+	routeConfigGen uint64
+	// routeConfigCache memoizes the virtual host table constructed for a
+	// given filter chain, so that Accept doesn't call
+	// FilterChain.ConstructUsableRouteConfiguration on every connection
+	// when the xDS configuration is stable between accepts. It is keyed on
+	// the filter chain's pointer identity, which is naturally invalidated
+	// when a new FilterChainManager (with new *FilterChain values) replaces
+	// the old one - switchMode reinitializes the map on every such
+	// replacement so entries for filter chains that are no longer in use
+	// don't accumulate for the life of the listener.
+	routeConfigCache map[*xdsclient.FilterChain]cachedRoutes
+}
+
+// cachedRoutes is one memoized entry in listenerWrapper.routeConfigCache.
+type cachedRoutes struct {
+	gen uint64
+	vhs []xdsclient.VirtualHostWithInterceptors
 }
 
 // Accept blocks on an Accept() on the underlying listener, and wraps the
@@ -314,24 +428,82 @@ func (l *listenerWrapper) Accept() (net.Conn, error) {
 			rcu := *rcuPtr
 			rc = rcu[fc.RouteConfigName]
 		}
-		// The filter chain will construct a usuable route table on each
-		// connection accept. This is done because preinstantiating every route
-		// table before it is needed for a connection would potentially lead to
-		// a lot of cpu time and memory allocated for route tables that will
-		// never be used. There was also a thought to cache this configuration,
-		// and reuse it for the next accepted connection. However, this would
-		// lead to a lot of code complexity (RDS Updates for a given route name
-		// can come it at any time), and connections aren't accepted too often,
-		// so this reinstantation of the Route Configuration is an acceptable
-		// tradeoff for simplicity.
-		vhswi, err := fc.ConstructUsableRouteConfiguration(rc)
+		// The route table for fc is memoized in routeConfigCache, keyed by
+		// its current generation, so that it is only reconstructed when an
+		// LDS or RDS update actually changes the configuration rather than
+		// on every accepted connection.
+		vhswi, err := l.usableRouteConfiguration(fc, rc)
 		if err != nil {
 			l.logger.Warningf("route configuration construction: %v", err)
 			conn.Close()
 			continue
 		}
-		return &connWrapper{Conn: conn, filterChain: fc, parent: l, virtualHosts: vhswi}, nil
+		cw := &connWrapper{
+			Conn:         conn,
+			filterChain:  fc,
+			parent:       l,
+			virtualHosts: vhswi,
+			interceptors: buildInterceptors(l.logger, fc),
+		}
+		l.addConn(cw)
+		return cw, nil
+	}
+}
+
+// addConn records cw as open, for ForceCloseConns to later find.
+func (l *listenerWrapper) addConn(cw *connWrapper) {
+	l.connsMu.Lock()
+	l.conns[cw] = struct{}{}
+	l.connsMu.Unlock()
+}
+
+// removeConn stops tracking cw, called once from connWrapper.Close.
+func (l *listenerWrapper) removeConn(cw *connWrapper) {
+	l.connsMu.Lock()
+	delete(l.conns, cw)
+	l.connsMu.Unlock()
+}
+
+// ForceCloseConns hard-closes every connection accepted by this listener
+// that has not already been closed, regardless of any RPCs still in
+// flight on it. It is invoked once a drain's grace period elapses with
+// connections still open, since neither a GOAWAY nor a second GOAWAY
+// gives grpc.Server any reason to actually tear down a connection whose
+// peer keeps a stream open past it.
+func (l *listenerWrapper) ForceCloseConns() {
+	l.connsMu.Lock()
+	conns := make([]*connWrapper, 0, len(l.conns))
+	for cw := range l.conns {
+		conns = append(conns, cw)
+	}
+	l.connsMu.Unlock()
+	for _, cw := range conns {
+		cw.Close()
+	}
+}
+
+// usableRouteConfiguration returns the virtual host table for fc, computing
+// it via fc.ConstructUsableRouteConfiguration and caching the result only
+// the first time it is needed for the current routeConfigGen; subsequent
+// calls for the same filter chain and generation are served from cache.
+func (l *listenerWrapper) usableRouteConfiguration(fc *xdsclient.FilterChain, rc xdsclient.RouteConfigUpdate) ([]xdsclient.VirtualHostWithInterceptors, error) {
+	l.mu.RLock()
+	gen := l.routeConfigGen
+	if cached, ok := l.routeConfigCache[fc]; ok && cached.gen == gen {
+		l.mu.RUnlock()
+		return cached.vhs, nil
 	}
+	l.mu.RUnlock()
+
+	vhs, err := fc.ConstructUsableRouteConfiguration(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.routeConfigCache[fc] = cachedRoutes{gen: gen, vhs: vhs}
+	l.mu.Unlock()
+	return vhs, nil
 }
 
 // Close closes the underlying listener. It also cancels the xDS watch
@@ -344,9 +516,66 @@ func (l *listenerWrapper) Close() error {
 		l.cancelWatch()
 	}
 	l.rdsHandler.close()
+	l.cancelDrain()
+	if l.oobReporter != nil {
+		l.oobReporter.Stop()
+	}
 	return nil
 }
 
+// ORCAService returns the Service that must be registered on the
+// grpc.Server serving this listener for ORCA out-of-band reporting to
+// work, or nil if ListenerWrapperParams.ORCAConfig did not enable ORCA
+// reporting.
+func (l *listenerWrapper) ORCAService() *orca.Service {
+	return l.orcaService
+}
+
+// cancelDrain cancels the context of any drain currently in progress, if
+// one exists, causing the server to hard-close connections that are still
+// draining rather than waiting out the rest of the grace period.
+func (l *listenerWrapper) cancelDrain() {
+	l.drainMu.Lock()
+	defer l.drainMu.Unlock()
+	if l.drainCancel != nil {
+		l.drainCancel()
+		l.drainCancel = nil
+	}
+}
+
+// onDrain invokes drainCallback with a context that is canceled once
+// drainGracePeriod elapses, and hard-closes any connection still open at
+// that point via ForceCloseConns - drainCallback itself only sends GOAWAY,
+// which asks a peer to stop issuing new RPCs but does nothing to a stream
+// it keeps open past the grace period. If a drain is already in progress,
+// the LDS update that triggered this call is folded into that existing
+// drain instead of starting a new grace period or invoking drainCallback
+// again.
+func (l *listenerWrapper) onDrain() {
+	l.drainMu.Lock()
+	if l.drainCancel != nil {
+		l.drainMu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.drainGracePeriod)
+	l.drainCancel = cancel
+	l.drainMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		l.drainMu.Lock()
+		if l.drainCancel != nil {
+			l.drainCancel = nil
+		}
+		l.drainMu.Unlock()
+		l.ForceCloseConns()
+	}()
+
+	if l.drainCallback != nil {
+		l.drainCallback(l.Listener.Addr(), ctx)
+	}
+}
+
 // run is a long running goroutine which handles all xds updates. LDS and RDS
 // push updates onto a channel which is read and acted upon from this goroutine.
 func (l *listenerWrapper) run() {
@@ -439,9 +668,7 @@ func (l *listenerWrapper) handleLDSUpdate(update ldsUpdateWithError) {
 	// Server's state to ServingModeNotServing. That prevents new connections
 	// from being accepted, whereas here we simply want the clients to reconnect
 	// to get the updated configuration.
-	if l.drainCallback != nil {
-		l.drainCallback(l.Listener.Addr())
-	}
+	l.onDrain()
 	l.rdsHandler.updateRouteNamesToWatch(ilc.FilterChains.RouteConfigNames)
 	// If there are no dynamic RDS Configurations still needed to be received
 	// from the management server, this listener has all the configuration
@@ -458,6 +685,14 @@ func (l *listenerWrapper) switchMode(fcs *xdsclient.FilterChainManager, newMode
 
 	l.filterChains = fcs
 	l.mode = newMode
+	l.routeConfigGen++
+	// Every *xdsclient.FilterChain cached under the old generation is
+	// gone the moment fcs replaces l.filterChains: a new LDS/RDS update
+	// always rebuilds filter chains (and hence their pointer identity)
+	// from scratch, even when nothing about them actually changed. Left
+	// alone, routeConfigCache would retain one stale entry per filter
+	// chain for every update for the life of the listener.
+	l.routeConfigCache = make(map[*xdsclient.FilterChain]cachedRoutes)
 	if l.modeCallback != nil {
 		l.modeCallback(l.Listener.Addr(), newMode, err)
 	}
@@ -0,0 +1,255 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiaohao9/grpc/xds/internal/xdsclient"
+)
+
+// stubAddr is a trivial net.Addr for use with stubListener below.
+type stubAddr struct{}
+
+func (stubAddr) Network() string { return "tcp" }
+func (stubAddr) String() string  { return "127.0.0.1:0" }
+
+// stubListener is a net.Listener whose only method exercised by the tests
+// below is Addr; Accept and Close are never called by onDrain/cancelDrain.
+type stubListener struct {
+	net.Listener
+	addr net.Addr
+}
+
+func (s stubListener) Addr() net.Addr { return s.addr }
+
+// stubConn is a net.Conn whose Close records that it was called, standing
+// in for a real connection's transport teardown in the ForceCloseConns
+// tests below.
+type stubConn struct {
+	net.Conn
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *stubConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *stubConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// BenchmarkAcceptRouteConfigCacheHit measures the cost of the
+// usableRouteConfiguration cache-hit path in isolation. The underlying
+// xdsclient.FilterChain type can't be meaningfully constructed outside of a
+// real xDS update in this package's tests, so this only exercises the cache
+// lookup, not a fresh call to ConstructUsableRouteConfiguration.
+func BenchmarkAcceptRouteConfigCacheHit(b *testing.B) {
+	fc := new(xdsclient.FilterChain)
+	vhs := []xdsclient.VirtualHostWithInterceptors{{}}
+	lw := &listenerWrapper{
+		routeConfigCache: map[*xdsclient.FilterChain]cachedRoutes{
+			fc: {gen: 0, vhs: vhs},
+		},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := lw.usableRouteConfiguration(fc, xdsclient.RouteConfigUpdate{}); err != nil {
+			b.Fatalf("usableRouteConfiguration returned error: %v", err)
+		}
+	}
+}
+
+// TestOnDrainCoalescesOverlappingUpdates simulates back-to-back LDS updates
+// (two calls to onDrain with no grace period elapsed in between, standing in
+// for a streaming RPC still active on the old connection) and verifies that
+// the second update is folded into the first drain instead of invoking
+// drainCallback, and hence the grace period, a second time.
+func TestOnDrainCoalescesOverlappingUpdates(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	lw := &listenerWrapper{
+		Listener:         stubListener{addr: stubAddr{}},
+		drainGracePeriod: time.Minute,
+		drainCallback: func(net.Addr, context.Context) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+		},
+	}
+
+	lw.onDrain()
+	lw.onDrain()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Fatalf("drainCallback invoked %d times for back-to-back LDS updates, want 1", calls)
+	}
+}
+
+// TestCancelDrainCancelsContext verifies that cancelDrain (invoked by Close)
+// cancels the context handed to drainCallback, so that connections which are
+// still draining when the server is closed are not held open for the rest
+// of the grace period.
+func TestCancelDrainCancelsContext(t *testing.T) {
+	var gotCtx context.Context
+	lw := &listenerWrapper{
+		Listener:         stubListener{addr: stubAddr{}},
+		drainGracePeriod: time.Minute,
+		drainCallback: func(_ net.Addr, ctx context.Context) {
+			gotCtx = ctx
+		},
+	}
+
+	lw.onDrain()
+	if gotCtx == nil {
+		t.Fatalf("drainCallback was not invoked by onDrain")
+	}
+	select {
+	case <-gotCtx.Done():
+		t.Fatalf("drain context was Done before the grace period elapsed or cancelDrain was called")
+	default:
+	}
+
+	lw.cancelDrain()
+	select {
+	case <-gotCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("drain context was not canceled by cancelDrain")
+	}
+}
+
+// TestOnDrainStartsNewGracePeriodAfterExpiry verifies that once a drain's
+// grace period elapses on its own, a later LDS update starts a fresh drain
+// (and grace period) rather than treating the listener as still draining.
+func TestOnDrainStartsNewGracePeriodAfterExpiry(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	lw := &listenerWrapper{
+		Listener:         stubListener{addr: stubAddr{}},
+		drainGracePeriod: 10 * time.Millisecond,
+		drainCallback: func(net.Addr, context.Context) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+		},
+	}
+
+	lw.onDrain()
+	time.Sleep(100 * time.Millisecond)
+	lw.onDrain()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Fatalf("got %d drainCallback invocations across two non-overlapping grace periods, want 2", calls)
+	}
+}
+
+// TestOnDrainForceClosesConnsAfterGracePeriod verifies that a connection
+// still tracked by a listenerWrapper when its drain's grace period expires
+// is hard-closed, since a long-lived RPC on it would otherwise keep it
+// open past DrainGracePeriod indefinitely - a GOAWAY alone does nothing to
+// a stream its peer keeps open.
+func TestOnDrainForceClosesConnsAfterGracePeriod(t *testing.T) {
+	lw := &listenerWrapper{
+		Listener:         stubListener{addr: stubAddr{}},
+		drainGracePeriod: 10 * time.Millisecond,
+		conns:            make(map[*connWrapper]struct{}),
+	}
+	sc := &stubConn{}
+	cw := &connWrapper{Conn: sc, parent: lw}
+	lw.addConn(cw)
+
+	lw.onDrain()
+
+	deadline := time.After(time.Second)
+	for !sc.isClosed() {
+		select {
+		case <-deadline:
+			t.Fatalf("connection was not force-closed within the grace period plus a margin")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// TestForceCloseConnsStopsTrackingClosedConns verifies that a connection
+// closed on its own (e.g. because the RPC finished and the client went
+// away) before the grace period expires is not tracked, and hence not
+// double-closed, by a later ForceCloseConns call.
+func TestForceCloseConnsStopsTrackingClosedConns(t *testing.T) {
+	lw := &listenerWrapper{conns: make(map[*connWrapper]struct{})}
+	sc := &stubConn{}
+	cw := &connWrapper{Conn: sc, parent: lw}
+	lw.addConn(cw)
+
+	if err := cw.Close(); err != nil {
+		t.Fatalf("cw.Close() failed: %v", err)
+	}
+	if _, tracked := lw.conns[cw]; tracked {
+		t.Fatalf("listenerWrapper still tracks a connection after it was Closed")
+	}
+
+	// A subsequent ForceCloseConns (e.g. from a concurrent drain) must not
+	// attempt to close cw again.
+	lw.ForceCloseConns()
+}
+
+// TestNewORCAReportingDisabled verifies that newORCAReporting returns no
+// service or reporter when ORCAConfig.Enable is false, the zero value of
+// ORCAConfig.
+func TestNewORCAReportingDisabled(t *testing.T) {
+	svc, reporter := newORCAReporting(ORCAConfig{})
+	if svc != nil || reporter != nil {
+		t.Fatalf("newORCAReporting(ORCAConfig{}) = %v, %v, want both nil", svc, reporter)
+	}
+}
+
+// TestNewORCAReportingEnabled verifies that newORCAReporting wires up a
+// non-nil Service and OOBReporter when ORCAConfig.Enable is set, and that a
+// zero ReportingInterval is defaulted rather than handed to the reporter
+// as-is.
+func TestNewORCAReportingEnabled(t *testing.T) {
+	svc, reporter := newORCAReporting(ORCAConfig{Enable: true})
+	defer reporter.Stop()
+	if svc == nil {
+		t.Errorf("newORCAReporting(ORCAConfig{Enable: true}) returned a nil *orca.Service")
+	}
+	if reporter == nil {
+		t.Fatalf("newORCAReporting(ORCAConfig{Enable: true}) returned a nil *orca.OOBReporter")
+	}
+	select {
+	case <-reporter.Reports():
+		t.Fatalf("reporter published a report before the default one-second interval elapsed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
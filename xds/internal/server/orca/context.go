@@ -0,0 +1,37 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package orca
+
+import "context"
+
+type recorderKey struct{}
+
+// NewContextWithMetricRecorder returns a copy of ctx carrying r, for
+// attaching to an RPC's context so that handler code can retrieve it via
+// MetricRecorderFromContext.
+func NewContextWithMetricRecorder(ctx context.Context, r *MetricRecorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, r)
+}
+
+// MetricRecorderFromContext returns the MetricRecorder previously attached
+// to ctx with NewContextWithMetricRecorder, if any.
+func MetricRecorderFromContext(ctx context.Context) (*MetricRecorder, bool) {
+	r, ok := ctx.Value(recorderKey{}).(*MetricRecorder)
+	return r, ok
+}
@@ -0,0 +1,77 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package orca
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/metadata"
+)
+
+// UnaryServerInterceptor attaches a fresh MetricRecorder to the RPC's
+// context, invokes handler, and - after handler returns but before the RPC
+// completes - serializes the values recorded on it into the RPC's trailing
+// metadata under TrailerMetadataKey, implementing the ORCA per-request
+// load reporting protocol. This has to be an interceptor rather than a
+// stats.Handler: by the time a stats.Handler observes HandleRPC(*stats.End),
+// the RPC's status and trailer have already been written to the wire, so
+// calling grpc.SetTrailer from there is a no-op.
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx = NewContextWithMetricRecorder(ctx, NewMetricRecorder())
+	resp, err := handler(ctx, req)
+	setTrailerMetricReport(ctx)
+	return resp, err
+}
+
+// StreamServerInterceptor is StreamServerInterceptor's streaming
+// counterpart: it attaches a fresh MetricRecorder to the stream's context
+// for the duration of handler, then reports it the same way.
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := NewContextWithMetricRecorder(ss.Context(), NewMetricRecorder())
+	err := handler(srv, &recordingServerStream{ServerStream: ss, ctx: ctx})
+	setTrailerMetricReport(ctx)
+	return err
+}
+
+// recordingServerStream overrides ServerStream.Context so the handler (and
+// anything it calls, e.g. via MetricRecorderFromContext) observes the
+// context StreamServerInterceptor attached the MetricRecorder to.
+type recordingServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *recordingServerStream) Context() context.Context { return s.ctx }
+
+// setTrailerMetricReport marshals the MetricRecorder attached to ctx, if
+// any, and sets it as ctx's outgoing trailer under TrailerMetadataKey.
+func setTrailerMetricReport(ctx context.Context) {
+	r, ok := MetricRecorderFromContext(ctx)
+	if !ok {
+		return
+	}
+	b, err := proto.Marshal(r.toLoadReport())
+	if err != nil {
+		return
+	}
+	grpc.SetTrailer(ctx, metadata.Pairs(TrailerMetadataKey, string(b)))
+}
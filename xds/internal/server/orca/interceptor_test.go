@@ -0,0 +1,118 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package orca
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/metadata"
+)
+
+// fakeServerTransportStream is a grpc.ServerTransportStream that records the
+// trailer set on it, standing in for the real transport since grpc.SetTrailer
+// only has an effect when one is attached to the context via
+// grpc.NewContextWithServerTransportStream, as the grpc.Server itself does
+// for every RPC.
+type fakeServerTransportStream struct {
+	trailer metadata.MD
+}
+
+func (*fakeServerTransportStream) Method() string               { return "" }
+func (*fakeServerTransportStream) SetHeader(metadata.MD) error  { return nil }
+func (*fakeServerTransportStream) SendHeader(metadata.MD) error { return nil }
+func (f *fakeServerTransportStream) SetTrailer(md metadata.MD) error {
+	f.trailer = metadata.Join(f.trailer, md)
+	return nil
+}
+
+// TestUnaryServerInterceptorSetsTrailer verifies that UnaryServerInterceptor
+// attaches the load report recorded by handler via MetricRecorderFromContext
+// to the RPC's trailer, and does so before returning rather than relying on
+// a later stats.Handler callback that would be too late to take effect.
+func TestUnaryServerInterceptorSetsTrailer(t *testing.T) {
+	fts := &fakeServerTransportStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), fts)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		r, ok := MetricRecorderFromContext(ctx)
+		if !ok {
+			t.Fatalf("handler could not find a MetricRecorder on its context")
+		}
+		r.SetCPUUtilization(0.5)
+		return nil, nil
+	}
+	if _, err := UnaryServerInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+		t.Fatalf("UnaryServerInterceptor returned unexpected error: %v", err)
+	}
+
+	assertLoadReportTrailer(t, fts.trailer)
+}
+
+// fakeServerStream is a minimal grpc.ServerStream whose Context returns a
+// caller-supplied context, enough for StreamServerInterceptor to layer its
+// own context modification on top of.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (f *fakeServerStream) Context() context.Context { return f.ctx }
+
+// TestStreamServerInterceptorSetsTrailer is TestUnaryServerInterceptorSetsTrailer's
+// streaming counterpart.
+func TestStreamServerInterceptorSetsTrailer(t *testing.T) {
+	fts := &fakeServerTransportStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), fts)
+	ss := &fakeServerStream{ctx: ctx}
+
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		r, ok := MetricRecorderFromContext(stream.Context())
+		if !ok {
+			t.Fatalf("handler could not find a MetricRecorder on its stream's context")
+		}
+		r.SetCPUUtilization(0.5)
+		return nil
+	}
+	if err := StreamServerInterceptor(nil, ss, &grpc.StreamServerInfo{}, handler); err != nil {
+		t.Fatalf("StreamServerInterceptor returned unexpected error: %v", err)
+	}
+
+	assertLoadReportTrailer(t, fts.trailer)
+}
+
+func assertLoadReportTrailer(t *testing.T, trailer metadata.MD) {
+	t.Helper()
+	vals := trailer.Get(TrailerMetadataKey)
+	if len(vals) != 1 {
+		t.Fatalf("trailer %v has %d values for %q, want 1", trailer, len(vals), TrailerMetadataKey)
+	}
+	r := NewMetricRecorder()
+	r.SetCPUUtilization(0.5)
+	want, err := proto.Marshal(r.toLoadReport())
+	if err != nil {
+		t.Fatalf("proto.Marshal(want) failed: %v", err)
+	}
+	if got := vals[0]; got != string(want) {
+		t.Errorf("trailer %q = %q, want %q", TrailerMetadataKey, got, want)
+	}
+}
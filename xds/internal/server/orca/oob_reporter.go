@@ -0,0 +1,84 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package orca
+
+import (
+	"time"
+
+	v3orcapb "github.com/cncf/xds/go/xds/data/orca/v3"
+)
+
+// OOBReporter periodically snapshots a MetricRecorder independent of any
+// particular RPC, for consumption by clients that subscribe to the named
+// out-of-band reporting stream (see Service) instead of the per-RPC
+// trailer.
+type OOBReporter struct {
+	recorder *MetricRecorder
+	reportCh chan *v3orcapb.OrcaLoadReport
+	done     chan struct{}
+}
+
+// NewOOBReporter starts a background goroutine that publishes a load-report
+// snapshot of recorder to Reports() every interval, until Stop is called.
+func NewOOBReporter(recorder *MetricRecorder, interval time.Duration) *OOBReporter {
+	o := &OOBReporter{
+		recorder: recorder,
+		reportCh: make(chan *v3orcapb.OrcaLoadReport, 1),
+		done:     make(chan struct{}),
+	}
+	go o.run(interval)
+	return o
+}
+
+func (o *OOBReporter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+			o.publish(o.recorder.toLoadReport())
+		}
+	}
+}
+
+// publish overwrites any unread snapshot on reportCh with rpt, so that
+// Reports() always yields the most recent report.
+func (o *OOBReporter) publish(rpt *v3orcapb.OrcaLoadReport) {
+	select {
+	case <-o.reportCh:
+	default:
+	}
+	select {
+	case o.reportCh <- rpt:
+	default:
+	}
+}
+
+// Reports returns the channel on which new load-report snapshots are
+// published.
+func (o *OOBReporter) Reports() <-chan *v3orcapb.OrcaLoadReport {
+	return o.reportCh
+}
+
+// Stop terminates the background reporting goroutine.
+func (o *OOBReporter) Stop() {
+	close(o.done)
+}
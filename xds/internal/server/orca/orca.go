@@ -0,0 +1,108 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package orca implements the server side of Open Request Cost Aggregation
+// (ORCA) for the xDS-enabled server listener: a per-RPC MetricRecorder that
+// application code stamps with utilization and request-cost metrics, and a
+// background reporter that turns those metrics into periodic out-of-band
+// load reports. Both feed load-aware client-side xDS balancers such as
+// ring_hash and weighted round robin.
+package orca
+
+import (
+	"sync"
+
+	v3orcapb "github.com/cncf/xds/go/xds/data/orca/v3"
+)
+
+// TrailerMetadataKey is the trailing metadata key under which a
+// binary-serialized OrcaLoadReport is attached to every RPC handled by a
+// server with ORCA reporting enabled, per the ORCA per-request protocol.
+const TrailerMetadataKey = "endpoint-load-metrics-bin"
+
+// MetricRecorder is retrieved from an RPC's context by application code to
+// report CPU, memory, and named utilization/cost metrics for that RPC. The
+// same recorder instance backs the server's out-of-band reporter, so values
+// set here are also visible in periodic load reports until overwritten.
+type MetricRecorder struct {
+	mu          sync.Mutex
+	cpu         float64
+	mem         float64
+	requestCost map[string]float64
+	utilization map[string]float64
+}
+
+// NewMetricRecorder returns a MetricRecorder with no metrics set.
+func NewMetricRecorder() *MetricRecorder {
+	return &MetricRecorder{
+		requestCost: make(map[string]float64),
+		utilization: make(map[string]float64),
+	}
+}
+
+// SetCPUUtilization records the server's current CPU utilization, in the
+// range [0, 1].
+func (r *MetricRecorder) SetCPUUtilization(v float64) {
+	r.mu.Lock()
+	r.cpu = v
+	r.mu.Unlock()
+}
+
+// SetMemoryUtilization records the server's current memory utilization, in
+// the range [0, 1].
+func (r *MetricRecorder) SetMemoryUtilization(v float64) {
+	r.mu.Lock()
+	r.mem = v
+	r.mu.Unlock()
+}
+
+// SetRequestCost records an application-defined named cost of the current
+// RPC, such as a database query count.
+func (r *MetricRecorder) SetRequestCost(name string, v float64) {
+	r.mu.Lock()
+	r.requestCost[name] = v
+	r.mu.Unlock()
+}
+
+// SetUtilization records an application-defined named utilization metric,
+// such as queue depth relative to capacity.
+func (r *MetricRecorder) SetUtilization(name string, v float64) {
+	r.mu.Lock()
+	r.utilization[name] = v
+	r.mu.Unlock()
+}
+
+// toLoadReport builds an OrcaLoadReport snapshot from the metrics currently
+// held by r.
+func (r *MetricRecorder) toLoadReport() *v3orcapb.OrcaLoadReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rpt := &v3orcapb.OrcaLoadReport{
+		CpuUtilization: r.cpu,
+		MemUtilization: r.mem,
+		RequestCost:    make(map[string]float64, len(r.requestCost)),
+		Utilization:    make(map[string]float64, len(r.utilization)),
+	}
+	for k, v := range r.requestCost {
+		rpt.RequestCost[k] = v
+	}
+	for k, v := range r.utilization {
+		rpt.Utilization[k] = v
+	}
+	return rpt
+}
@@ -0,0 +1,63 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package orca
+
+import (
+	v3orcaservicepb "github.com/cncf/xds/go/xds/service/orca/v3"
+
+	"github.com/qiaohao9/grpc"
+)
+
+// Service implements xds.service.orca.v3.OpenRcaService, letting clients
+// open a long-lived, named out-of-band stream for periodic load reports
+// instead of relying solely on the per-RPC trailer.
+type Service struct {
+	v3orcaservicepb.UnimplementedOpenRcaServiceServer
+
+	reporter *OOBReporter
+}
+
+// NewService returns a Service that streams the load-report snapshots
+// published by reporter to every client that opens the OOB stream.
+func NewService(reporter *OOBReporter) *Service {
+	return &Service{reporter: reporter}
+}
+
+// StreamCoreMetrics implements v3orcaservicepb.OpenRcaServiceServer. The
+// server is authoritative over reporting cadence, so the interval requested
+// in req is ignored in favor of the one the Service's OOBReporter was
+// created with.
+func (s *Service) StreamCoreMetrics(req *v3orcaservicepb.OrcaLoadReportRequest, stream v3orcaservicepb.OpenRcaService_StreamCoreMetricsServer) error {
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case rpt := <-s.reporter.Reports():
+			if err := stream.Send(rpt); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Register registers s as the OpenRcaService implementation on server.
+func Register(server *grpc.Server, s *Service) {
+	v3orcaservicepb.RegisterOpenRcaServiceServer(server, s)
+}
@@ -0,0 +1,273 @@
+//go:build !386
+// +build !386
+
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xds_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/internal/testutils"
+	"github.com/qiaohao9/grpc/metadata"
+	"github.com/qiaohao9/grpc/status"
+	"github.com/qiaohao9/grpc/xds/internal/testutils/e2e"
+
+	v3faultcommonpb "github.com/envoyproxy/go-control-plane/envoy/config/common/fault/v3"
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	v3faultpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	v3routerpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	v3httppb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	v3typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/types/known/durationpb"
+	testpb "github.com/qiaohao9/grpc/test/grpc_testing"
+)
+
+// fixedDelay builds a FaultDelay with a 100% trigger percentage and a
+// fixed delay of d.
+func fixedDelay(d time.Duration) *v3faultcommonpb.FaultDelay {
+	return &v3faultcommonpb.FaultDelay{
+		Percentage:         fullPercentage(),
+		FaultDelaySecifier: &v3faultcommonpb.FaultDelay_FixedDelay{FixedDelay: durationpb.New(d)},
+	}
+}
+
+// faultFilterChain builds a plaintext filter chain matching host:port whose
+// "fault" HTTP filter is configured with faultCfg, followed by "router" so
+// any RPC the fault filter does not abort still reaches
+// NonForwardingAction routing.
+func faultFilterChain(host string, port uint32, faultCfg *v3faultpb.HTTPFault) *v3listenerpb.Listener {
+	vhs := []*v3routepb.VirtualHost{
+		{
+			Domains: []string{"*"},
+			Routes: []*v3routepb.Route{
+				{
+					Match:  &v3routepb.RouteMatch{PathSpecifier: &v3routepb.RouteMatch_Prefix{Prefix: "/"}},
+					Action: &v3routepb.Route_NonForwardingAction{},
+				},
+			},
+		},
+	}
+	return &v3listenerpb.Listener{
+		Name: fmt.Sprintf(e2e.ServerListenerResourceNameTemplate, net.JoinHostPort(host, strconv.Itoa(int(port)))),
+		Address: &v3corepb.Address{
+			Address: &v3corepb.Address_SocketAddress{
+				SocketAddress: &v3corepb.SocketAddress{
+					Address:       host,
+					PortSpecifier: &v3corepb.SocketAddress_PortValue{PortValue: port},
+				},
+			},
+		},
+		FilterChains: []*v3listenerpb.FilterChain{
+			{
+				Name: "v4-wildcard",
+				FilterChainMatch: &v3listenerpb.FilterChainMatch{
+					PrefixRanges: []*v3corepb.CidrRange{
+						{AddressPrefix: "0.0.0.0", PrefixLen: &wrapperspb.UInt32Value{Value: 0}},
+					},
+					SourceType: v3listenerpb.FilterChainMatch_SAME_IP_OR_LOOPBACK,
+					SourcePrefixRanges: []*v3corepb.CidrRange{
+						{AddressPrefix: "0.0.0.0", PrefixLen: &wrapperspb.UInt32Value{Value: 0}},
+					},
+				},
+				Filters: []*v3listenerpb.Filter{
+					{
+						Name: "filter-1",
+						ConfigType: &v3listenerpb.Filter_TypedConfig{
+							TypedConfig: testutils.MarshalAny(&v3httppb.HttpConnectionManager{
+								HttpFilters: []*v3httppb.HttpFilter{
+									e2e.HTTPFilter("fault", faultCfg),
+									e2e.HTTPFilter("router", &v3routerpb.Router{}),
+								},
+								RouteSpecifier: &v3httppb.HttpConnectionManager_RouteConfig{
+									RouteConfig: &v3routepb.RouteConfiguration{
+										Name:         "routeName",
+										VirtualHosts: vhs,
+									},
+								},
+							}),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func fullPercentage() *v3typepb.FractionalPercent {
+	return &v3typepb.FractionalPercent{Numerator: 100, Denominator: v3typepb.FractionalPercent_HUNDRED}
+}
+
+// TestServerSideXDS_FaultInjection_Abort is an e2e test verifying that a
+// fault filter configured with a 100% abort denies every RPC with the
+// configured gRPC status.
+func (s) TestServerSideXDS_FaultInjection_Abort(t *testing.T) {
+	managementServer, nodeID, bootstrapContents, resolver, cleanup1 := setupManagementServer(t)
+	defer cleanup1()
+
+	lis, cleanup2 := setupGRPCServer(t, bootstrapContents)
+	defer cleanup2()
+
+	host, port, err := hostPortFromListener(lis)
+	if err != nil {
+		t.Fatalf("failed to retrieve host and port of server: %v", err)
+	}
+	const serviceName = "my-service-fault-abort"
+	resources := e2e.DefaultClientResources(e2e.ResourceParams{
+		DialTarget: serviceName,
+		NodeID:     nodeID,
+		Host:       host,
+		Port:       port,
+		SecLevel:   e2e.SecurityLevelNone,
+	})
+	resources.Listeners = append(resources.Listeners, faultFilterChain(host, port, &v3faultpb.HTTPFault{
+		Abort: &v3faultpb.FaultAbort{
+			ErrorType:  &v3faultpb.FaultAbort_GrpcStatus{GrpcStatus: uint32(codes.Unavailable)},
+			Percentage: fullPercentage(),
+		},
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+	if err := managementServer.Update(ctx, resources); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := grpc.DialContext(ctx, fmt.Sprintf("xds:///%s", serviceName), grpc.WithInsecure(), grpc.WithResolvers(resolver))
+	if err != nil {
+		t.Fatalf("failed to dial local test server: %v", err)
+	}
+	defer cc.Close()
+
+	client := testpb.NewTestServiceClient(cc)
+	if _, err := client.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true)); status.Code(err) != codes.Unavailable {
+		t.Fatalf("client.EmptyCall() = _, %v, want _, error code %s", err, codes.Unavailable)
+	}
+}
+
+// TestServerSideXDS_FaultInjection_Delay is an e2e test verifying that a
+// fault filter configured with a 100% fixed 50ms delay is observed as
+// added RPC latency by the client.
+func (s) TestServerSideXDS_FaultInjection_Delay(t *testing.T) {
+	managementServer, nodeID, bootstrapContents, resolver, cleanup1 := setupManagementServer(t)
+	defer cleanup1()
+
+	lis, cleanup2 := setupGRPCServer(t, bootstrapContents)
+	defer cleanup2()
+
+	host, port, err := hostPortFromListener(lis)
+	if err != nil {
+		t.Fatalf("failed to retrieve host and port of server: %v", err)
+	}
+	const serviceName = "my-service-fault-delay"
+	resources := e2e.DefaultClientResources(e2e.ResourceParams{
+		DialTarget: serviceName,
+		NodeID:     nodeID,
+		Host:       host,
+		Port:       port,
+		SecLevel:   e2e.SecurityLevelNone,
+	})
+	resources.Listeners = append(resources.Listeners, faultFilterChain(host, port, &v3faultpb.HTTPFault{
+		Delay: fixedDelay(50 * time.Millisecond),
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+	if err := managementServer.Update(ctx, resources); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := grpc.DialContext(ctx, fmt.Sprintf("xds:///%s", serviceName), grpc.WithInsecure(), grpc.WithResolvers(resolver))
+	if err != nil {
+		t.Fatalf("failed to dial local test server: %v", err)
+	}
+	defer cc.Close()
+
+	client := testpb.NewTestServiceClient(cc)
+	start := time.Now()
+	if _, err := client.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("client.EmptyCall() failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("EmptyCall() took %v, want at least 50ms of injected delay", elapsed)
+	}
+}
+
+// TestServerSideXDS_FaultInjection_HeaderGated is an e2e test verifying
+// that a fault filter configured to read its abort percentage and gRPC
+// status from request headers only aborts RPCs that carry those headers.
+func (s) TestServerSideXDS_FaultInjection_HeaderGated(t *testing.T) {
+	managementServer, nodeID, bootstrapContents, resolver, cleanup1 := setupManagementServer(t)
+	defer cleanup1()
+
+	lis, cleanup2 := setupGRPCServer(t, bootstrapContents)
+	defer cleanup2()
+
+	host, port, err := hostPortFromListener(lis)
+	if err != nil {
+		t.Fatalf("failed to retrieve host and port of server: %v", err)
+	}
+	const serviceName = "my-service-fault-header-gated"
+	resources := e2e.DefaultClientResources(e2e.ResourceParams{
+		DialTarget: serviceName,
+		NodeID:     nodeID,
+		Host:       host,
+		Port:       port,
+		SecLevel:   e2e.SecurityLevelNone,
+	})
+	resources.Listeners = append(resources.Listeners, faultFilterChain(host, port, &v3faultpb.HTTPFault{
+		Abort: &v3faultpb.FaultAbort{ErrorType: &v3faultpb.FaultAbort_HeaderAbort_{HeaderAbort: &v3faultpb.FaultAbort_HeaderAbort{}}},
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+	if err := managementServer.Update(ctx, resources); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := grpc.DialContext(ctx, fmt.Sprintf("xds:///%s", serviceName), grpc.WithInsecure(), grpc.WithResolvers(resolver))
+	if err != nil {
+		t.Fatalf("failed to dial local test server: %v", err)
+	}
+	defer cc.Close()
+
+	client := testpb.NewTestServiceClient(cc)
+
+	// No fault-injection headers set: the RPC should proceed normally.
+	if _, err := client.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("client.EmptyCall() without fault headers failed: %v, want success", err)
+	}
+
+	// With the fault-injection headers set, the RPC should be aborted with
+	// the requested gRPC status at the requested (100%) percentage.
+	gatedCtx := metadata.AppendToOutgoingContext(ctx, "x-envoy-fault-abort-request", "100", "x-envoy-fault-abort-grpc-request", strconv.Itoa(int(codes.Unavailable)))
+	if _, err := client.EmptyCall(gatedCtx, &testpb.Empty{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("client.EmptyCall() with fault headers = _, %v, want _, error code %s", err, codes.Unavailable)
+	}
+}
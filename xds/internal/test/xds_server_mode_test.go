@@ -0,0 +1,264 @@
+//go:build !386
+// +build !386
+
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xds_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/credentials/insecure"
+	"github.com/qiaohao9/grpc/status"
+	"github.com/qiaohao9/grpc/xds"
+	"github.com/qiaohao9/grpc/xds/internal/testutils/e2e"
+
+	testpb "github.com/qiaohao9/grpc/test/grpc_testing"
+	xdstestutils "github.com/qiaohao9/grpc/xds/internal/testutils"
+)
+
+// modeTransition records a single invocation of a ServingModeCallback.
+type modeTransition struct {
+	mode xds.ServingMode
+	err  error
+}
+
+// recordingModeCallback collects the mode transitions reported by an
+// xDS-enabled server, for assertions.
+type recordingModeCallback struct {
+	mu          sync.Mutex
+	transitions []modeTransition
+	updated     chan struct{}
+}
+
+func newRecordingModeCallback() *recordingModeCallback {
+	return &recordingModeCallback{updated: make(chan struct{}, 10)}
+}
+
+func (r *recordingModeCallback) callback(addr net.Addr, mode xds.ServingMode, err error) {
+	r.mu.Lock()
+	r.transitions = append(r.transitions, modeTransition{mode: mode, err: err})
+	r.mu.Unlock()
+	select {
+	case r.updated <- struct{}{}:
+	default:
+	}
+}
+
+func (r *recordingModeCallback) waitForMode(ctx context.Context, want xds.ServingMode) (modeTransition, error) {
+	for {
+		r.mu.Lock()
+		for _, tr := range r.transitions {
+			if tr.mode == want {
+				r.mu.Unlock()
+				return tr, nil
+			}
+		}
+		r.mu.Unlock()
+		select {
+		case <-r.updated:
+		case <-ctx.Done():
+			return modeTransition{}, ctx.Err()
+		}
+	}
+}
+
+// setupGRPCServerWithModeCallback is like setupGRPCServer, but additionally
+// registers cb to be notified of serving mode transitions and configures
+// drainGracePeriod as the time existing RPCs are given to complete once a
+// listener starts draining.
+func setupGRPCServerWithModeCallback(t *testing.T, bootstrapContents []byte, cb xds.ModeChangeCallback, drainGracePeriod time.Duration) (net.Listener, func()) {
+	t.Helper()
+
+	server := xds.NewGRPCServer(
+		grpc.Creds(insecure.NewCredentials()),
+		xds.BootstrapContentsForTesting(bootstrapContents),
+		xds.ServingModeCallback(cb),
+		xds.DrainGracePeriod(drainGracePeriod),
+	)
+	testpb.RegisterTestServiceServer(server, &testService{})
+
+	lis, err := xdstestutils.LocalTCPListener()
+	if err != nil {
+		t.Fatalf("testutils.LocalTCPListener() failed: %v", err)
+	}
+
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			t.Errorf("Serve() failed: %v", err)
+		}
+	}()
+
+	return lis, func() {
+		server.Stop()
+	}
+}
+
+// TestServerSideXDS_ServingMode_ListenerDeletion is an e2e test verifying
+// that deleting the inbound listener resource from the management server
+// moves the server into ServingModeNotServing, with the registered
+// ServingModeCallback reporting the resource-not-found error that caused
+// the transition, and that new RPCs sent after the transition are rejected
+// with codes.Unavailable.
+func (s) TestServerSideXDS_ServingMode_ListenerDeletion(t *testing.T) {
+	managementServer, nodeID, bootstrapContents, resolver, cleanup1 := setupManagementServer(t)
+	defer cleanup1()
+
+	modeCb := newRecordingModeCallback()
+	lis, cleanup2 := setupGRPCServerWithModeCallback(t, bootstrapContents, modeCb.callback, defaultTestTimeout)
+	defer cleanup2()
+
+	host, port, err := hostPortFromListener(lis)
+	if err != nil {
+		t.Fatalf("failed to retrieve host and port of server: %v", err)
+	}
+	const serviceName = "my-service-mode-listener-deletion"
+	resources := e2e.DefaultClientResources(e2e.ResourceParams{
+		DialTarget: serviceName,
+		NodeID:     nodeID,
+		Host:       host,
+		Port:       port,
+		SecLevel:   e2e.SecurityLevelNone,
+	})
+	inboundLis := e2e.DefaultServerListener(host, port, e2e.SecurityLevelNone)
+	resources.Listeners = append(resources.Listeners, inboundLis)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+	if err := managementServer.Update(ctx, resources); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := grpc.DialContext(ctx, fmt.Sprintf("xds:///%s", serviceName), grpc.WithInsecure(), grpc.WithResolvers(resolver))
+	if err != nil {
+		t.Fatalf("failed to dial local test server: %v", err)
+	}
+	defer cc.Close()
+
+	client := testpb.NewTestServiceClient(cc)
+	if _, err := client.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("client.EmptyCall() before listener deletion failed: %v", err)
+	}
+
+	// Remove the inbound listener resource, without re-adding it, so that
+	// the server's LDS watch times out with a resource-not-found error.
+	noServerResources := e2e.DefaultClientResources(e2e.ResourceParams{
+		DialTarget: serviceName,
+		NodeID:     nodeID,
+		Host:       host,
+		Port:       port,
+		SecLevel:   e2e.SecurityLevelNone,
+	})
+	if err := managementServer.Update(ctx, noServerResources); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := modeCb.waitForMode(ctx, xds.ServingModeNotServing)
+	if err != nil {
+		t.Fatalf("timed out waiting for ServingModeCallback to report not-serving: %v", err)
+	}
+	if tr.err == nil {
+		t.Errorf("ServingModeCallback reported not-serving with a nil error, want non-nil (resource-not-found)")
+	}
+
+	if _, err := client.EmptyCall(ctx, &testpb.Empty{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("client.EmptyCall() after listener deletion = _, %v, want _, error code %s", err, codes.Unavailable)
+	}
+}
+
+// TestServerSideXDS_ServingMode_DrainAllowsInFlightRPCToComplete is an e2e
+// test verifying that an RPC already in flight when the inbound listener is
+// deleted is allowed to complete within the configured drain grace period,
+// even though new RPCs are rejected immediately.
+func (s) TestServerSideXDS_ServingMode_DrainAllowsInFlightRPCToComplete(t *testing.T) {
+	managementServer, nodeID, bootstrapContents, resolver, cleanup1 := setupManagementServer(t)
+	defer cleanup1()
+
+	modeCb := newRecordingModeCallback()
+	lis, cleanup2 := setupGRPCServerWithModeCallback(t, bootstrapContents, modeCb.callback, defaultTestTimeout)
+	defer cleanup2()
+
+	host, port, err := hostPortFromListener(lis)
+	if err != nil {
+		t.Fatalf("failed to retrieve host and port of server: %v", err)
+	}
+	const serviceName = "my-service-mode-drain"
+	resources := e2e.DefaultClientResources(e2e.ResourceParams{
+		DialTarget: serviceName,
+		NodeID:     nodeID,
+		Host:       host,
+		Port:       port,
+		SecLevel:   e2e.SecurityLevelNone,
+	})
+	inboundLis := e2e.DefaultServerListener(host, port, e2e.SecurityLevelNone)
+	resources.Listeners = append(resources.Listeners, inboundLis)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+	if err := managementServer.Update(ctx, resources); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := grpc.DialContext(ctx, fmt.Sprintf("xds:///%s", serviceName), grpc.WithInsecure(), grpc.WithResolvers(resolver))
+	if err != nil {
+		t.Fatalf("failed to dial local test server: %v", err)
+	}
+	defer cc.Close()
+	client := testpb.NewTestServiceClient(cc)
+
+	stream, err := client.StreamingOutputCall(ctx, &testpb.StreamingOutputCallRequest{})
+	if err != nil {
+		t.Fatalf("client.StreamingOutputCall() failed: %v", err)
+	}
+
+	noServerResources := e2e.DefaultClientResources(e2e.ResourceParams{
+		DialTarget: serviceName,
+		NodeID:     nodeID,
+		Host:       host,
+		Port:       port,
+		SecLevel:   e2e.SecurityLevelNone,
+	})
+	if err := managementServer.Update(ctx, noServerResources); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := modeCb.waitForMode(ctx, xds.ServingModeNotServing); err != nil {
+		t.Fatalf("timed out waiting for ServingModeCallback to report not-serving: %v", err)
+	}
+
+	// The stream that was already established must still be allowed to
+	// complete normally (EOF), since the drain grace period configured
+	// above is the test's default timeout.
+	var streamErr error
+	for {
+		if _, streamErr = stream.Recv(); streamErr != nil {
+			break
+		}
+	}
+	if streamErr != io.EOF {
+		t.Errorf("in-flight stream ended with %v, want io.EOF", streamErr)
+	}
+}
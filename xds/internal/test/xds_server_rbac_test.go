@@ -0,0 +1,345 @@
+//go:build !386
+// +build !386
+
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xds_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/credentials/insecure"
+	"github.com/qiaohao9/grpc/internal/testutils"
+	"github.com/qiaohao9/grpc/status"
+	"github.com/qiaohao9/grpc/xds"
+	"github.com/qiaohao9/grpc/xds/internal/httpfilter/rbac"
+	"github.com/qiaohao9/grpc/xds/internal/testutils/e2e"
+
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	v3rbacconfigpb "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	v3rbacpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/rbac/v3"
+	v3routerpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	v3httppb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	v3matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
+	xdscreds "github.com/qiaohao9/grpc/credentials/xds"
+	testpb "github.com/qiaohao9/grpc/test/grpc_testing"
+	xdstestutils "github.com/qiaohao9/grpc/xds/internal/testutils"
+)
+
+// recordingAuditSink is an rbac.AuditSink that records every Decision it
+// receives, for assertions in the tests below.
+type recordingAuditSink struct {
+	mu        sync.Mutex
+	decisions []rbac.Decision
+}
+
+func (r *recordingAuditSink) LogDecision(d rbac.Decision) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decisions = append(r.decisions, d)
+}
+
+func (r *recordingAuditSink) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.decisions)
+}
+
+// setupGRPCServerWithAuditSink is setupGRPCServer, but additionally wires
+// sink into the server's RBAC HTTP filter processing via
+// xds.ServerOption, so test cases can assert on allow/deny decisions
+// without parsing server logs.
+func setupGRPCServerWithAuditSink(t *testing.T, bootstrapContents []byte, sink rbac.AuditSink) (net.Listener, func()) {
+	t.Helper()
+
+	creds, err := xdscreds.NewServerCredentials(xdscreds.ServerOptions{
+		FallbackCreds: insecure.NewCredentials(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := xds.NewGRPCServer(grpc.Creds(creds), xds.BootstrapContentsForTesting(bootstrapContents), xds.RBACAuditSink(sink))
+	testpb.RegisterTestServiceServer(server, &testService{})
+
+	lis, err := xdstestutils.LocalTCPListener()
+	if err != nil {
+		t.Fatalf("testutils.LocalTCPListener() failed: %v", err)
+	}
+
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			t.Errorf("Serve() failed: %v", err)
+		}
+	}()
+
+	return lis, func() {
+		server.Stop()
+	}
+}
+
+// rbacFilterChain builds a filter chain matching host:port that only
+// allows access to EmptyCall, via the "rbac" HTTP filter followed by
+// "router", so that a matching RPC still reaches NonForwardingAction
+// routing.
+func rbacFilterChain(host string, port uint32, rbacCfg *v3rbacconfigpb.RBAC) *v3listenerpb.Listener {
+	vhs := []*v3routepb.VirtualHost{
+		{
+			Domains: []string{"*"},
+			Routes: []*v3routepb.Route{
+				{
+					Match:  &v3routepb.RouteMatch{PathSpecifier: &v3routepb.RouteMatch_Prefix{Prefix: "/"}},
+					Action: &v3routepb.Route_NonForwardingAction{},
+				},
+			},
+		},
+	}
+	return &v3listenerpb.Listener{
+		Name: fmt.Sprintf(e2e.ServerListenerResourceNameTemplate, net.JoinHostPort(host, strconv.Itoa(int(port)))),
+		Address: &v3corepb.Address{
+			Address: &v3corepb.Address_SocketAddress{
+				SocketAddress: &v3corepb.SocketAddress{
+					Address:       host,
+					PortSpecifier: &v3corepb.SocketAddress_PortValue{PortValue: port},
+				},
+			},
+		},
+		FilterChains: []*v3listenerpb.FilterChain{
+			{
+				Name: "v4-wildcard",
+				FilterChainMatch: &v3listenerpb.FilterChainMatch{
+					PrefixRanges: []*v3corepb.CidrRange{
+						{AddressPrefix: "0.0.0.0", PrefixLen: &wrapperspb.UInt32Value{Value: 0}},
+					},
+					SourceType: v3listenerpb.FilterChainMatch_SAME_IP_OR_LOOPBACK,
+					SourcePrefixRanges: []*v3corepb.CidrRange{
+						{AddressPrefix: "0.0.0.0", PrefixLen: &wrapperspb.UInt32Value{Value: 0}},
+					},
+				},
+				Filters: []*v3listenerpb.Filter{
+					{
+						Name: "filter-1",
+						ConfigType: &v3listenerpb.Filter_TypedConfig{
+							TypedConfig: testutils.MarshalAny(&v3httppb.HttpConnectionManager{
+								HttpFilters: []*v3httppb.HttpFilter{
+									e2e.HTTPFilter("rbac", &v3rbacpb.RBAC{Rules: rbacCfg}),
+									e2e.HTTPFilter("router", &v3routerpb.Router{}),
+								},
+								RouteSpecifier: &v3httppb.HttpConnectionManager_RouteConfig{
+									RouteConfig: &v3routepb.RouteConfiguration{
+										Name:         "routeName",
+										VirtualHosts: vhs,
+									},
+								},
+							}),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// TestServerSideXDS_RBAC_DenyByDefault is an e2e test verifying that an
+// RBAC filter with action ALLOW and no policies denies every RPC with
+// codes.PermissionDenied, per the rbac package's documented semantics for
+// an RBAC filter present with empty policies.
+func (s) TestServerSideXDS_RBAC_DenyByDefault(t *testing.T) {
+	managementServer, nodeID, bootstrapContents, resolver, cleanup1 := setupManagementServer(t)
+	defer cleanup1()
+
+	sink := &recordingAuditSink{}
+	lis, cleanup2 := setupGRPCServerWithAuditSink(t, bootstrapContents, sink)
+	defer cleanup2()
+
+	host, port, err := hostPortFromListener(lis)
+	if err != nil {
+		t.Fatalf("failed to retrieve host and port of server: %v", err)
+	}
+	const serviceName = "my-service-rbac-deny-by-default"
+	resources := e2e.DefaultClientResources(e2e.ResourceParams{
+		DialTarget: serviceName,
+		NodeID:     nodeID,
+		Host:       host,
+		Port:       port,
+		SecLevel:   e2e.SecurityLevelNone,
+	})
+	resources.Listeners = append(resources.Listeners, rbacFilterChain(host, port, &v3rbacconfigpb.RBAC{Action: v3rbacconfigpb.RBAC_ALLOW}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+	if err := managementServer.Update(ctx, resources); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := grpc.DialContext(ctx, fmt.Sprintf("xds:///%s", serviceName), grpc.WithInsecure(), grpc.WithResolvers(resolver))
+	if err != nil {
+		t.Fatalf("failed to dial local test server: %v", err)
+	}
+	defer cc.Close()
+
+	client := testpb.NewTestServiceClient(cc)
+	if _, err := client.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true)); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("client.EmptyCall() = _, %v, want _, error code %s", err, codes.PermissionDenied)
+	}
+	if got := sink.len(); got == 0 {
+		t.Errorf("audit sink recorded 0 decisions, want at least 1")
+	}
+}
+
+// TestServerSideXDS_RBAC_PerRoutePolicy is an e2e test verifying that an
+// RBAC policy scoped to a single method (via a url_path permission) allows
+// that method while denying others, confirming the per-route nature of the
+// permission match rather than any connection-wide allow.
+func (s) TestServerSideXDS_RBAC_PerRoutePolicy(t *testing.T) {
+	managementServer, nodeID, bootstrapContents, resolver, cleanup1 := setupManagementServer(t)
+	defer cleanup1()
+
+	lis, cleanup2 := setupGRPCServer(t, bootstrapContents)
+	defer cleanup2()
+
+	host, port, err := hostPortFromListener(lis)
+	if err != nil {
+		t.Fatalf("failed to retrieve host and port of server: %v", err)
+	}
+	const serviceName = "my-service-rbac-per-route"
+	resources := e2e.DefaultClientResources(e2e.ResourceParams{
+		DialTarget: serviceName,
+		NodeID:     nodeID,
+		Host:       host,
+		Port:       port,
+		SecLevel:   e2e.SecurityLevelNone,
+	})
+	rbacCfg := &v3rbacconfigpb.RBAC{
+		Action: v3rbacconfigpb.RBAC_ALLOW,
+		Policies: map[string]*v3rbacconfigpb.Policy{
+			"allow-empty-call-only": {
+				Permissions: []*v3rbacconfigpb.Permission{{
+					Rule: &v3rbacconfigpb.Permission_UrlPath{
+						UrlPath: &v3matcherpb.PathMatcher{
+							Rule: &v3matcherpb.PathMatcher_Path{
+								Path: &v3matcherpb.StringMatcher{
+									MatchPattern: &v3matcherpb.StringMatcher_Exact{Exact: "/grpc.testing.TestService/EmptyCall"},
+								},
+							},
+						},
+					},
+				}},
+				Principals: []*v3rbacconfigpb.Principal{{Identifier: &v3rbacconfigpb.Principal_Any{Any: true}}},
+			},
+		},
+	}
+	resources.Listeners = append(resources.Listeners, rbacFilterChain(host, port, rbacCfg))
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+	if err := managementServer.Update(ctx, resources); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := grpc.DialContext(ctx, fmt.Sprintf("xds:///%s", serviceName), grpc.WithInsecure(), grpc.WithResolvers(resolver))
+	if err != nil {
+		t.Fatalf("failed to dial local test server: %v", err)
+	}
+	defer cc.Close()
+
+	client := testpb.NewTestServiceClient(cc)
+	if _, err := client.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("client.EmptyCall() failed: %v, want success since it matches the allow-empty-call-only policy", err)
+	}
+	if _, err := client.UnaryCall(ctx, &testpb.SimpleRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("client.UnaryCall() = _, %v, want _, error code %s since no policy permits it", err, codes.PermissionDenied)
+	}
+}
+
+// TestServerSideXDS_RBAC_AuthenticatedPrincipal is an e2e test verifying
+// that an RBAC policy using Principal_Authenticated matches an mTLS peer's
+// certificate SAN, exercised with the file watcher certificate provider
+// the same way TestServerSideXDS_FileWatcherCerts is.
+func (s) TestServerSideXDS_RBAC_AuthenticatedPrincipal(t *testing.T) {
+	managementServer, nodeID, bootstrapContents, resolver, cleanup1 := setupManagementServer(t)
+	defer cleanup1()
+
+	lis, cleanup2 := setupGRPCServer(t, bootstrapContents)
+	defer cleanup2()
+
+	host, port, err := hostPortFromListener(lis)
+	if err != nil {
+		t.Fatalf("failed to retrieve host and port of server: %v", err)
+	}
+	const serviceName = "my-service-rbac-authenticated-principal"
+	resources := e2e.DefaultClientResources(e2e.ResourceParams{
+		DialTarget: serviceName,
+		NodeID:     nodeID,
+		Host:       host,
+		Port:       port,
+		SecLevel:   e2e.SecurityLevelMTLS,
+	})
+	rbacCfg := &v3rbacconfigpb.RBAC{
+		Action: v3rbacconfigpb.RBAC_ALLOW,
+		Policies: map[string]*v3rbacconfigpb.Policy{
+			"allow-any-authenticated-client": {
+				Permissions: []*v3rbacconfigpb.Permission{{Rule: &v3rbacconfigpb.Permission_Any{Any: true}}},
+				Principals: []*v3rbacconfigpb.Principal{{
+					Identifier: &v3rbacconfigpb.Principal_Authenticated_{
+						Authenticated: &v3rbacconfigpb.Principal_Authenticated{},
+					},
+				}},
+			},
+		},
+	}
+	inboundLis := rbacFilterChain(host, port, rbacCfg)
+	// rbacFilterChain sets up a plaintext filter chain match; splice in the
+	// mTLS transport socket the same way e2e.DefaultServerListener does for
+	// SecurityLevelMTLS.
+	mtlsLis := e2e.DefaultServerListener(host, port, e2e.SecurityLevelMTLS)
+	inboundLis.FilterChains[0].TransportSocket = mtlsLis.FilterChains[0].TransportSocket
+	resources.Listeners = append(resources.Listeners, inboundLis)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+	if err := managementServer.Update(ctx, resources); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := xdscreds.NewClientCredentials(xdscreds.ClientOptions{FallbackCreds: insecure.NewCredentials()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := grpc.DialContext(ctx, fmt.Sprintf("xds:///%s", serviceName), grpc.WithTransportCredentials(creds), grpc.WithResolvers(resolver))
+	if err != nil {
+		t.Fatalf("failed to dial local test server: %v", err)
+	}
+	defer cc.Close()
+
+	client := testpb.NewTestServiceClient(cc)
+	if _, err := client.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("rpc EmptyCall() failed: %v, want success since the mTLS client is an authenticated principal", err)
+	}
+}
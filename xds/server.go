@@ -0,0 +1,346 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package xds is the entry point for the xDS-enabled gRPC server side API.
+package xds
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/internal"
+	"github.com/qiaohao9/grpc/xds/internal/httpfilter"
+	"github.com/qiaohao9/grpc/xds/internal/httpfilter/rbac"
+	iserver "github.com/qiaohao9/grpc/xds/internal/server"
+	"github.com/qiaohao9/grpc/xds/internal/server/orca"
+	"github.com/qiaohao9/grpc/xds/internal/xdsclient"
+)
+
+// serverListenerResourceNameTemplate is the template used to construct the
+// xDS Listener resource name requested for the inbound listener passed to
+// Serve, matching the template configured on the management server by
+// e2e.ServerListenerResourceNameTemplate in tests.
+const serverListenerResourceNameTemplate = "grpc/server?xds.resource.listening_address=%s"
+
+// ServingMode indicates the current mode of operation of an xDS-enabled
+// gRPC server created via NewGRPCServer.
+//
+// This type mirrors xds/internal/server.ServingMode rather than aliasing
+// it: that internal package is imported by this one, so it cannot import
+// this package back to use this type directly. ServingModeCallback values
+// are translated between the two at the point where NewGRPCServer adapts
+// them into a iserver.ServingModeCallback.
+type ServingMode int
+
+const (
+	// ServingModeStarting indicates that the server is starting up.
+	ServingModeStarting ServingMode = iota
+	// ServingModeServing indicates that the server contains all required
+	// xDS configuration and is serving RPCs.
+	ServingModeServing
+	// ServingModeNotServing indicates that the server is not accepting new
+	// connections, because it does not (or no longer) contain the
+	// required xDS configuration to serve RPCs. Existing connections are
+	// drained per gRFC A36 rather than closed outright.
+	ServingModeNotServing
+)
+
+func (s ServingMode) String() string {
+	switch s {
+	case ServingModeServing:
+		return "serving"
+	case ServingModeNotServing:
+		return "not-serving"
+	default:
+		return "starting"
+	}
+}
+
+// ModeChangeCallback is the signature of the callback that can be
+// registered, via the ServingModeCallback ServerOption, to be notified of
+// an xDS-enabled server's serving mode changes. It is invoked with the
+// address of the listener that changed mode, the new mode and, when the
+// new mode is ServingModeNotServing, the error that caused the transition.
+type ModeChangeCallback func(addr net.Addr, mode ServingMode, err error)
+
+// serverOptions are the xDS-specific settings accumulated from the
+// ServerOption values passed to NewGRPCServer.
+type serverOptions struct {
+	modeCallback                ModeChangeCallback
+	drainGracePeriod            time.Duration
+	bootstrapContentsForTesting []byte
+	rbacAuditSink               rbac.AuditSink
+	orcaConfig                  iserver.ORCAConfig
+}
+
+// serverOption implements grpc.ServerOption for the xDS-specific options
+// below, by embedding grpc.EmptyServerOption: applying one to the
+// underlying grpc.Server is a no-op, since NewGRPCServer recognizes and
+// strips these out of the incoming options before constructing it, using
+// them instead to configure the xDS-specific behavior below.
+type serverOption struct {
+	grpc.EmptyServerOption
+	apply func(*serverOptions)
+}
+
+// ServingModeCallback returns a ServerOption that registers cb to be
+// invoked, once Serve has been called, whenever the serving mode of the
+// returned server changes.
+func ServingModeCallback(cb ModeChangeCallback) grpc.ServerOption {
+	return &serverOption{apply: func(o *serverOptions) { o.modeCallback = cb }}
+}
+
+// DrainGracePeriod returns a ServerOption that configures d as the amount
+// of time, per gRFC A36, that existing connections are given to let
+// long-lived RPCs finish after a Listener update before being hard-closed.
+// It defaults to ten minutes if this option is not used.
+func DrainGracePeriod(d time.Duration) grpc.ServerOption {
+	return &serverOption{apply: func(o *serverOptions) { o.drainGracePeriod = d }}
+}
+
+// BootstrapContentsForTesting returns a ServerOption that overrides the
+// bootstrap configuration the server would otherwise read from the
+// GRPC_XDS_BOOTSTRAP and GRPC_XDS_BOOTSTRAP_CONFIG environment variables,
+// for use by tests that spin up their own management server.
+func BootstrapContentsForTesting(contents []byte) grpc.ServerOption {
+	return &serverOption{apply: func(o *serverOptions) { o.bootstrapContentsForTesting = contents }}
+}
+
+// ORCAListenerMetrics returns a ServerOption that enables Open Request Cost
+// Aggregation load reporting, as configured by cfg, on every listener
+// created by Serve. This covers both the per-RPC trailer-based reports and,
+// if cfg.Enable is set, the out-of-band reporting service.
+func ORCAListenerMetrics(cfg iserver.ORCAConfig) grpc.ServerOption {
+	return &serverOption{apply: func(o *serverOptions) { o.orcaConfig = cfg }}
+}
+
+// RBACAuditSink returns a ServerOption that registers sink to receive a
+// Decision for every RPC evaluated by an RBAC HTTP filter configured on
+// any of this server's filter chains. The RBAC proto itself has no room
+// for a Go-level audit sink, so this is configured independently of the
+// xDS configuration delivered to the server.
+func RBACAuditSink(sink rbac.AuditSink) grpc.ServerOption {
+	return &serverOption{apply: func(o *serverOptions) { o.rbacAuditSink = sink }}
+}
+
+// GRPCServer wraps a grpc.Server and provides the xDS-specific
+// functionality to determine the serving configuration for the server,
+// watch for and apply updates to that configuration, and gracefully drain
+// connections when it changes. Use RegisterService and Serve in place of
+// the corresponding methods on the underlying grpc.Server.
+type GRPCServer struct {
+	gs   *grpc.Server
+	opts serverOptions
+}
+
+// NewGRPCServer creates an xDS-enabled gRPC server using the passed in
+// opts. Any xDS-specific ServerOption (ServingModeCallback,
+// DrainGracePeriod, BootstrapContentsForTesting, RBACAuditSink,
+// ORCAListenerMetrics) is
+// consumed by the xDS machinery below; every other grpc.ServerOption (such
+// as grpc.Creds) is forwarded unmodified to the underlying grpc.Server.
+func NewGRPCServer(opts ...grpc.ServerOption) *GRPCServer {
+	var so serverOptions
+	grpcOpts := make([]grpc.ServerOption, 0, len(opts))
+	for _, opt := range opts {
+		if xo, ok := opt.(*serverOption); ok {
+			xo.apply(&so)
+			continue
+		}
+		grpcOpts = append(grpcOpts, opt)
+	}
+
+	s := &GRPCServer{opts: so}
+	grpcOpts = append(grpcOpts,
+		grpc.ConnContext(connContext),
+		grpc.ChainUnaryInterceptor(s.xdsUnaryInterceptor),
+		grpc.ChainStreamInterceptor(s.xdsStreamInterceptor),
+	)
+	if so.orcaConfig.Enable {
+		// The per-RPC load report has to be attached by an interceptor,
+		// not a stats.Handler: by the time a stats.Handler observes
+		// HandleRPC(*stats.End), the RPC's status and trailer have
+		// already gone out on the wire, so grpc.SetTrailer from there is
+		// a no-op. This is stateless, so it can be installed once here
+		// rather than threaded through per-listener, unlike the OOB
+		// reporting Service registered in Serve, which carries the
+		// listener's own load data and lifecycle.
+		grpcOpts = append(grpcOpts,
+			grpc.ChainUnaryInterceptor(orca.UnaryServerInterceptor),
+			grpc.ChainStreamInterceptor(orca.StreamServerInterceptor),
+		)
+	}
+	s.gs = grpc.NewServer(grpcOpts...)
+	return s
+}
+
+// connContextKey is the context key under which connContext stores the
+// iserver.RouteInterceptors for an accepted connection, for
+// interceptorsFromContext to recover.
+type connContextKey struct{}
+
+// connContext is installed via grpc.ConnContext on every GRPCServer, and
+// stashes the iserver.RouteInterceptors that iserver.InterceptorsFromConn
+// resolved for c (if any) on every RPC's context on that connection, for
+// interceptorsFromContext to resolve per RPC once the method name is
+// known - a route with its own FilterConfigOverride can carry different
+// interceptors than the connection-level ones, so this can't be resolved
+// once for the whole connection the way it used to be.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	ri := iserver.InterceptorsFromConn(c)
+	if ri == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, connContextKey{}, ri)
+}
+
+// interceptorsFromContext returns the HTTP filter interceptors configured
+// for fullMethod on the connection ctx's RPC arrived on, or nil if there
+// are none (for example, because the RPC did not arrive over a connection
+// accepted by an xDS-enabled listener).
+func interceptorsFromContext(ctx context.Context, fullMethod string) []httpfilter.ServerInterceptor {
+	ri, ok := ctx.Value(connContextKey{}).(iserver.RouteInterceptors)
+	if !ok {
+		return nil
+	}
+	return ri.InterceptorsForRoute(fullMethod)
+}
+
+// allowRPC runs every HTTP filter interceptor configured for fullMethod on
+// ctx's connection, in order, applying s's configured RBACAuditSink to any
+// RBAC interceptor among them first. It returns the first non-nil error
+// encountered, or nil if every interceptor allowed the RPC.
+func (s *GRPCServer) allowRPC(ctx context.Context, fullMethod string) error {
+	for _, si := range interceptorsFromContext(ctx, fullMethod) {
+		if rsi, ok := si.(*rbac.ServerInterceptor); ok && s.opts.rbacAuditSink != nil {
+			rsi.SetAuditSink(s.opts.rbacAuditSink)
+		}
+		if err := si.AllowRPC(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterService registers a service and its implementation to the
+// underlying gRPC server, exactly as (*grpc.Server).RegisterService does.
+func (s *GRPCServer) RegisterService(sd *grpc.ServiceDesc, ss interface{}) {
+	s.gs.RegisterService(sd, ss)
+}
+
+// Serve creates an xDS-enabled listener wrapping lis, which watches the
+// inbound xDS Listener resource corresponding to lis's address for its
+// serving configuration, and blocks serving RPCs on it - delegating to the
+// underlying grpc.Server - until Stop is called or the listener encounters
+// a non-temporary error while accepting connections.
+func (s *GRPCServer) Serve(lis net.Listener) error {
+	if _, ok := lis.Addr().(*net.TCPAddr); !ok {
+		return fmt.Errorf("xds: Serve: only TCP listeners are supported, got address of type %T", lis.Addr())
+	}
+
+	xdsC, err := newXDSClient(s.opts.bootstrapContentsForTesting)
+	if err != nil {
+		return fmt.Errorf("xds: failed to create xDS client: %v", err)
+	}
+
+	wrappedLis, _ := iserver.NewListenerWrapper(iserver.ListenerWrapperParams{
+		Listener:             lis,
+		ListenerResourceName: fmt.Sprintf(serverListenerResourceNameTemplate, lis.Addr().String()),
+		XDSClient:            xdsC,
+		ModeCallback:         s.modeCallback,
+		DrainCallback:        s.drainCallback,
+		DrainGracePeriod:     s.opts.drainGracePeriod,
+		ORCAConfig:           s.opts.orcaConfig,
+	})
+	// wrappedLis only exposes ORCAService when ORCAConfig.Enable was set;
+	// recovered via a type assertion the same way net/http recovers
+	// Hijacker, since iserver.NewListenerWrapper returns a plain
+	// net.Listener.
+	if wl, ok := wrappedLis.(interface{ ORCAService() *orca.Service }); ok {
+		if svc := wl.ORCAService(); svc != nil {
+			orca.Register(s.gs, svc)
+		}
+	}
+	return s.gs.Serve(wrappedLis)
+}
+
+// Stop stops the underlying grpc.Server. It immediately closes all open
+// connections, without waiting for any in-flight RPCs to complete.
+func (s *GRPCServer) Stop() {
+	s.gs.Stop()
+}
+
+// drainCallback adapts a listenerWrapper's DrainCallback, invoked when the
+// inbound Listener resource is updated, to the underlying grpc.Server: it
+// immediately sends GOAWAY on every connection accepted through addr via
+// the internal.DrainServerTransports hook, allowing in-flight RPCs to
+// complete. A GOAWAY only asks a peer to stop issuing new RPCs on the
+// connection, though - it does nothing to a stream the peer keeps open
+// past drainCtx's deadline, so the listenerWrapper that invoked this
+// callback is also responsible for hard-closing any connection still open
+// once drainCtx expires (see listenerWrapper.onDrain/ForceCloseConns);
+// this callback's job is only the initial GOAWAY.
+func (s *GRPCServer) drainCallback(addr net.Addr, drainCtx context.Context) {
+	drain, ok := internal.DrainServerTransports.(func(*grpc.Server, string))
+	if !ok {
+		return
+	}
+	drain(s.gs, addr.String())
+}
+
+// modeCallback adapts an iserver.ServingMode, as reported by the
+// listenerWrapper created in Serve, to the public ServingMode type before
+// invoking the user-registered callback, if any.
+func (s *GRPCServer) modeCallback(addr net.Addr, mode iserver.ServingMode, err error) {
+	if s.opts.modeCallback != nil {
+		s.opts.modeCallback(addr, ServingMode(mode), err)
+	}
+}
+
+// xdsUnaryInterceptor is installed on every GRPCServer and runs the
+// connection's configured HTTP filters (e.g. RBAC, fault injection) ahead
+// of the application's unary handler, failing the RPC without invoking the
+// handler if any of them returns an error.
+func (s *GRPCServer) xdsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.allowRPC(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// xdsStreamInterceptor is the streaming counterpart to xdsUnaryInterceptor.
+func (s *GRPCServer) xdsStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.allowRPC(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// newXDSClient creates the xDS client used to watch this server's xDS
+// configuration, using bootstrapContentsForTesting in place of the
+// GRPC_XDS_BOOTSTRAP / GRPC_XDS_BOOTSTRAP_CONFIG environment variables when
+// non-empty.
+func newXDSClient(bootstrapContentsForTesting []byte) (iserver.XDSClient, error) {
+	if len(bootstrapContentsForTesting) > 0 {
+		return xdsclient.NewWithBootstrapContentsForTesting(bootstrapContentsForTesting)
+	}
+	return xdsclient.New()
+}